@@ -2,9 +2,13 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"time"
@@ -17,10 +21,10 @@ import (
 )
 
 var (
-	storageService         *services.StorageService
-	conversionService      *services.ConversionService
-	directDownloadService  *services.DirectDownloadService
-	youtubeService         *services.YouTubeService
+	storageService        *services.StorageService
+	conversionService     *services.ConversionService
+	directDownloadService *services.DirectDownloadService
+	sourceRegistry        *services.SourceRegistry
 )
 
 func main() {
@@ -36,19 +40,58 @@ func main() {
 
 	// Initialize services
 	storageService = services.NewStorageService(config.AppConfig.AbsCompletedDir)
+	readinessProber := services.NewReadinessProber(config.AppConfig.FileReadyTimeout)
+	youtubeService := services.NewYouTubeServiceWithKeys(services.NewKeyPool(
+		config.AppConfig.RapidAPIKeys,
+		config.AppConfig.RapidAPIHosts,
+		config.AppConfig.RapidAPIKeyStrategy,
+		config.AppConfig.RapidAPIKeyCooldown,
+	))
+	youtubeService.CacheTTL = config.AppConfig.ResolutionCacheTTL
+	youtubeService.DailyBudget = config.AppConfig.RapidAPIDailyBudget
+
+	providers := map[string]services.VideoSource{"rapidapi": youtubeService}
+	if config.AppConfig.InvidiousHost != "" {
+		providers["invidious"] = services.NewInvidiousSource(config.AppConfig.InvidiousHost)
+	}
+	resolverChain := services.NewResolverChain(
+		config.AppConfig.ResolverChain,
+		providers,
+		config.AppConfig.ResolverBreakerLimit,
+		config.AppConfig.ResolverBreakerCooldown,
+	)
+	sourceRegistry = services.NewSourceRegistry(resolverChain)
+	jobQueue := services.NewJobQueue(config.AppConfig.DownloadWorkers, config.AppConfig.TranscodeWorkers)
+
 	conversionService = services.NewConversionService(
 		config.AppConfig.AbsOngoingDir,
 		config.AppConfig.AbsCompletedDir,
 		storageService,
+		readinessProber,
+		sourceRegistry,
+		jobQueue,
 	)
 	directDownloadService = services.NewDirectDownloadService(
 		config.AppConfig.AbsOngoingDir,
 		config.AppConfig.AbsCompletedDir,
+		readinessProber,
+		sourceRegistry,
+		jobQueue,
 	)
-	youtubeService = services.NewYouTubeService(
-		config.AppConfig.RapidAPIKey,
-		config.AppConfig.RapidAPIHost,
-	)
+
+	conversionService.DownloadSegments = config.AppConfig.DownloadSegments
+	directDownloadService.DownloadSegments = config.AppConfig.DownloadSegments
+
+	bandwidthSchedule, err := services.ParseBandwidthSchedule(config.AppConfig.BandwidthSchedule)
+	if err != nil {
+		log.Printf("Warning: Ignoring BANDWIDTH_SCHEDULE: %v", err)
+	}
+	bandwidthLimiter := services.NewBandwidthLimiter(config.AppConfig.BandwidthLimit, bandwidthSchedule)
+	conversionService.Bandwidth = bandwidthLimiter
+	directDownloadService.Bandwidth = bandwidthLimiter
+
+	jobQueue.Register(models.QueueKindConversion, conversionService)
+	jobQueue.Register(models.QueueKindDownload, directDownloadService)
 
 	// Load existing conversions
 	if err := conversionService.LoadFromDatabase(); err != nil {
@@ -66,7 +109,10 @@ func main() {
 		fmt.Println("  2. convert - Convert an MP4 file")
 		fmt.Println("  3. status - Check conversion status")
 		fmt.Println("  4. download - Download a YouTube video")
-		fmt.Println("  5. quit - Exit")
+		fmt.Println("  5. cancel - Cancel a job running on the server")
+		fmt.Println("  6. pause - Pause a job running on the server")
+		fmt.Println("  7. resume - Resume a paused job on the server")
+		fmt.Println("  8. quit - Exit")
 		fmt.Print("\nEnter command: ")
 
 		input, _ := reader.ReadString('\n')
@@ -81,7 +127,13 @@ func main() {
 			checkStatus()
 		case "4", "download":
 			downloadVideo(reader)
-		case "5", "quit", "exit":
+		case "5", "cancel":
+			jobAction(reader, "cancel")
+		case "6", "pause":
+			jobAction(reader, "pause")
+		case "7", "resume":
+			jobAction(reader, "resume")
+		case "8", "quit", "exit":
 			fmt.Println("Goodbye!")
 			return
 		default:
@@ -197,9 +249,9 @@ func convertFile(reader *bufio.Reader) {
 	// Start conversion
 	inputPath := filepath.Join(config.AppConfig.AbsOngoingDir, selectedFile)
 	outputFilename := strings.TrimSuffix(selectedFile, ".mp4") + "." + format
-	outputPath := filepath.Join(config.AppConfig.AbsCompletedDir, outputFilename)
+	outputPath := filepath.Join(config.AppConfig.AbsOngoingDir, strings.TrimSuffix(selectedFile, ".mp4")+".converted."+format)
 
-	fmt.Printf("\nConverting %s to %s...\n", selectedFile, format)
+	fmt.Printf("\nConverting %s to %s (Ctrl+C to cancel)...\n", selectedFile, format)
 
 	// Create a simple job for tracking
 	jobID := fmt.Sprintf("cli_%d", time.Now().Unix())
@@ -216,8 +268,10 @@ func convertFile(reader *bufio.Reader) {
 		log.Printf("Warning: Failed to save job: %v", err)
 	}
 
-	// Build and run ffmpeg command
-	cmd := utils.BuildFFmpegCommand(inputPath, outputPath, format)
+	// Build and run ffmpeg command. Ctrl+C kills ffmpeg instead of the CLI.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cmd := utils.BuildFFmpegCommand(ctx, inputPath, outputPath, format)
 
 	// Show progress indicator
 	done := make(chan bool)
@@ -241,6 +295,21 @@ func convertFile(reader *bufio.Reader) {
 	done <- true
 	fmt.Print("\r") // Clear spinner line
 
+	// Only a verified output is moved into the completed directory
+	if ctx.Err() != nil {
+		os.Remove(outputPath)
+		fmt.Println("Conversion cancelled.")
+		job.Status = services.StatusCancelled
+		endTime := time.Now()
+		job.EndTime = &endTime
+		database.SaveConversion(job)
+		return
+	} else if err == nil {
+		job.SHA256, err = services.PublishFile(outputPath, filepath.Join(config.AppConfig.AbsCompletedDir, outputFilename))
+	} else {
+		os.Remove(outputPath)
+	}
+
 	if err != nil {
 		fmt.Printf("✗ Conversion failed: %v\n", err)
 		job.Status = "failed"
@@ -300,17 +369,74 @@ func checkStatus() {
 					fmt.Printf("Completed: %s\n", endTime.(time.Time).Format("2006-01-02 15:04:05"))
 				}
 			}
+		case "downloading":
+			{
+				done, _ := job["bytesDone"].(int64)
+				total, _ := job["bytesTotal"].(int64)
+				rate, _ := job["bytesPerSecond"].(float64)
+				eta, _ := job["etaSeconds"].(int)
+				if total > 0 {
+					fmt.Printf("Progress: %s\n", transferSummary(done, total, rate, eta))
+				}
+			}
 		case "failed":
 			{
 				if errorMsg, ok := job["error"].(string); ok && errorMsg != "" {
 					fmt.Printf("Error: %s\n", errorMsg)
 				}
+				if message, ok := job["errorMessage"].(string); ok && message != "" {
+					fmt.Printf("Reason: %s\n", message)
+				}
+				if canRetry, ok := job["canRetry"].(bool); ok && !canRetry {
+					fmt.Println("This job can't be retried.")
+				}
 			}
 		}
 
 	}
 }
 
+// jobAction asks the server to cancel, pause or resume one of its jobs. Jobs
+// started from this CLI are cancelled with Ctrl+C instead.
+func jobAction(reader *bufio.Reader, action string) {
+	fmt.Print("\nEnter job ID: ")
+	jobID, _ := reader.ReadString('\n')
+	jobID = strings.TrimSpace(jobID)
+
+	if jobID == "" {
+		fmt.Println("Job ID cannot be empty.")
+		return
+	}
+
+	url := config.AppConfig.ServerURL + "/api/jobs/" + jobID + "/" + action
+	resp, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		fmt.Printf("Failed to reach the server: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Printf("✗ Failed to %s job: %s\n", action, strings.TrimSpace(string(body)))
+		return
+	}
+	fmt.Printf("✓ Job %s: %s requested\n", jobID, action)
+}
+
+// transferSummary formats download progress as "42% of 1.2 GB at 3.1 MB/s,
+// 5m left".
+func transferSummary(done, total int64, rate float64, eta int) string {
+	summary := fmt.Sprintf("%d%% of %s", done*100/total, storageService.FormatFileSize(total))
+	if rate > 0 {
+		summary += fmt.Sprintf(" at %s/s", storageService.FormatFileSize(int64(rate)))
+	}
+	if eta > 0 {
+		summary += fmt.Sprintf(", %s left", time.Duration(eta)*time.Second)
+	}
+	return summary
+}
+
 func downloadVideo(reader *bufio.Reader) {
 	fmt.Println("\n=== Download YouTube Video ===")
 
@@ -324,93 +450,96 @@ func downloadVideo(reader *bufio.Reader) {
 	}
 
 	// Extract video ID
-	videoID, err := youtubeService.ExtractVideoID(url)
+	source, videoID, err := sourceRegistry.Lookup(url)
 	if err != nil {
-		fmt.Printf("Invalid YouTube URL: %v\n", err)
+		fmt.Printf("Invalid video URL: %v\n", err)
 		return
 	}
 
 	fmt.Printf("\nExtracted video ID: %s\n", videoID)
 
-	// Get download URL from RapidAPI
-	fmt.Println("Getting download URL...")
-	rapidResp, err := youtubeService.GetDownloadURL(videoID)
-	if err != nil {
-		fmt.Printf("Failed to get download URL: %v\n", err)
-		return
-	}
+	fmt.Print("Maximum height, e.g. 1080 (blank for default): ")
+	heightInput, _ := reader.ReadString('\n')
+	heightInput = strings.TrimSpace(heightInput)
 
-	// Wait for file to be ready
-	fmt.Println("Waiting for file to be ready (this may take up to 20 seconds)...")
-	youtubeService.WaitForFileReady()
-	fmt.Println("File should be ready now")
-
-	// Fetch video title
-	videoTitle := rapidResp.Title
-	if videoTitle == "" {
-		fmt.Println("Fetching video title...")
-		if title, err := youtubeService.GetVideoTitle(videoID); err == nil {
-			videoTitle = title
-		} else {
-			fmt.Printf("Warning: could not fetch video title: %v\n", err)
-			videoTitle = videoID
+	var quality models.QualitySelection
+	if heightInput != "" {
+		if _, err := fmt.Sscanf(heightInput, "%d", &quality.MaxHeight); err != nil {
+			fmt.Println("Invalid height.")
+			return
 		}
 	}
 
-	fmt.Printf("Video title: %s\n", videoTitle)
+	fmt.Print("Parallel connections (blank for default): ")
+	segmentsInput, _ := reader.ReadString('\n')
+	segmentsInput = strings.TrimSpace(segmentsInput)
 
-	// Sanitize filename
-	sanitizedTitle := sanitizeFilename(videoTitle)
-	if sanitizedTitle == "" {
-		sanitizedTitle = videoID
+	var segments int
+	if segmentsInput != "" {
+		if _, err := fmt.Sscanf(segmentsInput, "%d", &segments); err != nil || segments < 1 || segments > services.MaxDownloadSegments {
+			fmt.Printf("Invalid number of connections, use 1 to %d.\n", services.MaxDownloadSegments)
+			return
+		}
 	}
-	filename := sanitizedTitle + ".mp4"
 
-	// Create download record
+	// Create download record and resolve it in the background
 	downloadID := fmt.Sprintf("%s_%d", videoID, time.Now().Unix())
-	download := directDownloadService.CreateDownload(downloadID, url, filename)
+	download := directDownloadService.CreateDownload(downloadID, url, "", segments, 0)
+
+	fmt.Printf("Getting download URL from %s (Ctrl+C to cancel)...\n", source.Name())
+	directDownloadService.Enqueue(download, quality)
 
-	// Process download in background
-	fmt.Printf("\nDownloading %s...\n", filename)
-	go directDownloadService.ProcessDownload(download, rapidResp.File)
+	interrupt, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
 	// Wait for download to complete
-	fmt.Println("Waiting for download to complete...")
+	lastStatus := download.Status
 	for {
-		time.Sleep(2 * time.Second)
+		select {
+		case <-time.After(2 * time.Second):
+		case <-interrupt.Done():
+			stop()
+			fmt.Println()
+			if err := directDownloadService.CancelDownload(downloadID); err != nil {
+				fmt.Printf("Failed to cancel download: %v\n", err)
+			} else {
+				fmt.Println("Download cancelled.")
+			}
+			return
+		}
 		download, exists := directDownloadService.GetDownload(downloadID)
 		if !exists {
 			fmt.Println("Error: Download record not found")
 			return
 		}
 
+		if download.Status != lastStatus && download.Status == "processing" {
+			fmt.Printf("\nDownloading %s...\n", download.Filename)
+		}
+		lastStatus = download.Status
+
+		if download.Status == "processing" && download.BytesTotal > 0 {
+			fmt.Printf("\r%s   ", transferSummary(download.BytesDone, download.BytesTotal, download.TransferRate, download.ETASeconds))
+		}
+
 		if download.Status == "completed" {
-			fmt.Printf("✓ Download completed: %s\n", filename)
-			fmt.Printf("File saved to: %s\n", filepath.Join(config.AppConfig.AbsCompletedDir, filename))
+			fmt.Println()
+			fmt.Printf("✓ Download completed: %s\n", download.Filename)
+			fmt.Printf("File saved to: %s\n", filepath.Join(config.AppConfig.AbsCompletedDir, download.Filename))
 			return
 		}
 
 		if download.Status == "failed" {
+			fmt.Println()
 			errMsg := "Unknown error"
 			if download.Error != nil {
 				errMsg = *download.Error
 			}
 			fmt.Printf("✗ Download failed: %s\n", errMsg)
+			if message := services.ErrorMessage(download.ErrorCode); message != "" {
+				fmt.Println(message)
+			}
 			return
 		}
 	}
 }
-
-func sanitizeFilename(filename string) string {
-	// Remove invalid characters for filenames
-	invalid := []string{"/", "\\", ":", "*", "?", "\"", "<", ">", "|"}
-	result := filename
-	for _, char := range invalid {
-		result = strings.ReplaceAll(result, char, "")
-	}
-	// Limit length
-	if len(result) > 200 {
-		result = result[:200]
-	}
-	return strings.TrimSpace(result)
-}
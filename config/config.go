@@ -4,6 +4,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,10 +17,49 @@ const (
 )
 
 type Config struct {
-	RapidAPIKey  string
-	RapidAPIHost string
-	DatabaseURL  string
-	ExecDir      string
+	RapidAPIKeys  []string
+	RapidAPIHosts []string
+	DatabaseURL   string
+	ExecDir       string
+
+	RapidAPIKeyStrategy string
+	RapidAPIKeyCooldown time.Duration
+
+	// ResolverChain lists providers in the order they are tried
+	ResolverChain           []string
+	InvidiousHost           string
+	ResolverBreakerLimit    int
+	ResolverBreakerCooldown time.Duration
+
+	// Direct media links are limited to these hosts (any public host when
+	// the allowlist is empty) and to MediaURLMaxSize bytes.
+	MediaURLAllowlist []string
+	MediaURLDenylist  []string
+	MediaURLMaxSize   int64
+
+	UploadMaxSize int64
+
+	// DownloadSegments is the default number of parallel connections per
+	// download
+	DownloadSegments int
+
+	// BandwidthLimit caps all downloads together, in bytes per second (0 for
+	// unlimited). BandwidthSchedule overrides it at certain times of day.
+	BandwidthLimit    int64
+	BandwidthSchedule string
+
+	// DownloadWorkers and TranscodeWorkers limit how many jobs download and
+	// run ffmpeg at once; the rest wait in the queue.
+	DownloadWorkers  int
+	TranscodeWorkers int
+
+	// ServerURL is where the CLI reaches the web server to act on its jobs
+	ServerURL string
+
+	FileReadyTimeout         time.Duration
+	SubscriptionPollInterval time.Duration
+	ResolutionCacheTTL       time.Duration
+	RapidAPIDailyBudget      int
 
 	AbsCompletedDir string
 	AbsOngoingDir   string
@@ -30,31 +72,84 @@ func Load() error {
 		log.Println("No .env file found, using environment variables")
 	}
 
-	rapidAPIKey := os.Getenv("RAPIDAPI_KEY")
-	rapidAPIHost := os.Getenv("RAPIDAPI_HOST")
+	// RAPIDAPI_KEYS/RAPIDAPI_HOSTS take comma separated lists; the single
+	// RAPIDAPI_KEY/RAPIDAPI_HOST variables still work on their own.
+	rapidAPIKeys := getList("RAPIDAPI_KEYS", os.Getenv("RAPIDAPI_KEY"))
+	rapidAPIHosts := getList("RAPIDAPI_HOSTS", os.Getenv("RAPIDAPI_HOST"))
 	databaseURL := os.Getenv("GOOSE_DBSTRING")
 
-	if rapidAPIKey == "" {
-		log.Fatal("RAPIDAPI_KEY environment variable is required")
+	if len(rapidAPIKeys) == 0 {
+		log.Fatal("RAPIDAPI_KEY or RAPIDAPI_KEYS environment variable is required")
 	}
-	if rapidAPIHost == "" {
-		log.Fatal("RAPIDAPI_HOST environment variable is required")
+	if len(rapidAPIHosts) == 0 {
+		log.Fatal("RAPIDAPI_HOST or RAPIDAPI_HOSTS environment variable is required")
 	}
 	if databaseURL == "" {
 		log.Fatal("GOOSE_DBSTRING environment variable is required")
 	}
 
+	fileReadyTimeout := getDuration("FILE_READY_TIMEOUT", 2*time.Minute)
+	subscriptionPollInterval := getDuration("SUBSCRIPTION_POLL_INTERVAL", 15*time.Minute)
+	resolutionCacheTTL := getDuration("RESOLUTION_CACHE_TTL", time.Hour)
+	rapidAPIDailyBudget := getInt("RAPIDAPI_DAILY_BUDGET", 0)
+	rapidAPIKeyCooldown := getDuration("RAPIDAPI_KEY_COOLDOWN", 15*time.Minute)
+
+	resolverChain := getList("RESOLVER_CHAIN", "rapidapi")
+	invidiousHost := os.Getenv("INVIDIOUS_HOST")
+	resolverBreakerLimit := getInt("RESOLVER_BREAKER_THRESHOLD", 3)
+	resolverBreakerCooldown := getDuration("RESOLVER_BREAKER_COOLDOWN", 5*time.Minute)
+
+	mediaURLAllowlist := getList("MEDIA_URL_ALLOWLIST", "")
+	mediaURLDenylist := getList("MEDIA_URL_DENYLIST", "")
+	mediaURLMaxSize := int64(getInt("MEDIA_URL_MAX_SIZE_MB", 2048)) << 20
+	uploadMaxSize := int64(getInt("UPLOAD_MAX_SIZE_MB", 2048)) << 20
+	downloadSegments := getInt("DOWNLOAD_SEGMENTS", 4)
+	bandwidthLimit := int64(getInt("BANDWIDTH_LIMIT_KB", 0)) << 10
+	bandwidthSchedule := os.Getenv("BANDWIDTH_SCHEDULE")
+	downloadWorkers := getInt("DOWNLOAD_WORKERS", 3)
+	transcodeWorkers := getInt("TRANSCODE_WORKERS", 1)
+
+	serverURL := strings.TrimSuffix(os.Getenv("SERVER_URL"), "/")
+	if serverURL == "" {
+		serverURL = "http://localhost:8080"
+	}
+
+	rapidAPIKeyStrategy := os.Getenv("RAPIDAPI_KEY_STRATEGY")
+	if rapidAPIKeyStrategy == "" {
+		rapidAPIKeyStrategy = "round_robin"
+	}
+
 	execDir := getExecutableDir()
 	absOngoingDir := filepath.Join(execDir, OngoingDir)
 	absCompletedDir := filepath.Join(execDir, CompletedDir)
 
 	AppConfig = &Config{
-		RapidAPIKey:     rapidAPIKey,
-		RapidAPIHost:    rapidAPIHost,
-		DatabaseURL:     databaseURL,
-		ExecDir:         execDir,
-		AbsCompletedDir: absCompletedDir,
-		AbsOngoingDir:   absOngoingDir,
+		RapidAPIKeys:             rapidAPIKeys,
+		RapidAPIHosts:            rapidAPIHosts,
+		RapidAPIKeyStrategy:      rapidAPIKeyStrategy,
+		RapidAPIKeyCooldown:      rapidAPIKeyCooldown,
+		ResolverChain:            resolverChain,
+		InvidiousHost:            invidiousHost,
+		ResolverBreakerLimit:     resolverBreakerLimit,
+		ResolverBreakerCooldown:  resolverBreakerCooldown,
+		MediaURLAllowlist:        mediaURLAllowlist,
+		MediaURLDenylist:         mediaURLDenylist,
+		MediaURLMaxSize:          mediaURLMaxSize,
+		UploadMaxSize:            uploadMaxSize,
+		DownloadSegments:         downloadSegments,
+		BandwidthLimit:           bandwidthLimit,
+		BandwidthSchedule:        bandwidthSchedule,
+		DownloadWorkers:          downloadWorkers,
+		TranscodeWorkers:         transcodeWorkers,
+		ServerURL:                serverURL,
+		DatabaseURL:              databaseURL,
+		ExecDir:                  execDir,
+		FileReadyTimeout:         fileReadyTimeout,
+		SubscriptionPollInterval: subscriptionPollInterval,
+		ResolutionCacheTTL:       resolutionCacheTTL,
+		RapidAPIDailyBudget:      rapidAPIDailyBudget,
+		AbsCompletedDir:          absCompletedDir,
+		AbsOngoingDir:            absOngoingDir,
 	}
 
 	// Create directories
@@ -68,6 +163,48 @@ func Load() error {
 	return nil
 }
 
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// getList splits a comma separated variable, using fallback when it is unset.
+func getList(key, fallback string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		value = fallback
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
+
 func getExecutableDir() string {
 	if dir := os.Getenv("EXEC_DIR"); dir != "" {
 		return dir
@@ -1,10 +1,13 @@
 package database
 
 import (
+	"time"
+
 	"github.com/vicradon/yt-downloader/models"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -41,3 +44,187 @@ func GetDirectDownload(id string) (*models.DirectDownload, error) {
 	result := DB.Where("id = ?", id).First(&download)
 	return &download, result.Error
 }
+
+func LoadDirectDownloads() ([]models.DirectDownload, error) {
+	var downloads []models.DirectDownload
+	result := DB.Order("download_time DESC").Find(&downloads)
+	return downloads, result.Error
+}
+
+func SaveBatch(batch *models.Batch) error {
+	return DB.Save(batch).Error
+}
+
+func GetBatch(id string) (*models.Batch, error) {
+	var batch models.Batch
+	result := DB.Where("id = ?", id).First(&batch)
+	return &batch, result.Error
+}
+
+func LoadBatches() ([]models.Batch, error) {
+	var batches []models.Batch
+	result := DB.Order("created_at DESC").Find(&batches)
+	return batches, result.Error
+}
+
+func LoadBatchConversions(batchID string) ([]models.ConversionJob, error) {
+	var jobs []models.ConversionJob
+	result := DB.Where("batch_id = ?", batchID).Order("start_time").Find(&jobs)
+	return jobs, result.Error
+}
+
+func LoadBatchDirectDownloads(batchID string) ([]models.DirectDownload, error) {
+	var downloads []models.DirectDownload
+	result := DB.Where("batch_id = ?", batchID).Order("download_time").Find(&downloads)
+	return downloads, result.Error
+}
+
+// UpdateBatchProgress recounts the finished jobs of a batch and stores the
+// result on the batch record.
+func UpdateBatchProgress(batchID string) error {
+	batch, err := GetBatch(batchID)
+	if err != nil {
+		return err
+	}
+
+	table := "direct_downloads"
+	if batch.Convert {
+		table = "conversion_jobs"
+	}
+
+	var counts []struct {
+		Status string
+		Count  int
+	}
+	if err := DB.Table(table).Select("status, count(*) as count").
+		Where("batch_id = ?", batchID).Group("status").Scan(&counts).Error; err != nil {
+		return err
+	}
+
+	batch.Completed, batch.Failed = 0, 0
+	for _, c := range counts {
+		switch c.Status {
+		case "completed":
+			batch.Completed = c.Count
+		// Cancelled and interrupted videos won't be fetched either, so they
+		// count as failed
+		case "failed", "cancelled", "interrupted":
+			batch.Failed += c.Count
+		}
+	}
+
+	if batch.Total > 0 {
+		batch.Progress = float64(batch.Completed+batch.Failed) / float64(batch.Total)
+	}
+	switch {
+	case batch.Completed+batch.Failed < batch.Total:
+		batch.Status = "processing"
+	case batch.Failed == 0:
+		batch.Status = "completed"
+	case batch.Completed == 0:
+		batch.Status = "failed"
+	default:
+		batch.Status = "partial"
+	}
+
+	return SaveBatch(batch)
+}
+
+func SaveSubscription(subscription *models.Subscription) error {
+	return DB.Save(subscription).Error
+}
+
+func GetSubscription(id uint) (*models.Subscription, error) {
+	var subscription models.Subscription
+	result := DB.Where("id = ?", id).First(&subscription)
+	return &subscription, result.Error
+}
+
+func LoadSubscriptions() ([]models.Subscription, error) {
+	var subscriptions []models.Subscription
+	result := DB.Order("id").Find(&subscriptions)
+	return subscriptions, result.Error
+}
+
+func DeleteSubscription(id uint) error {
+	return DB.Delete(&models.Subscription{}, id).Error
+}
+
+// VideoSeen reports whether a subscription has already handled a video.
+func VideoSeen(subscriptionID uint, videoID string) (bool, error) {
+	var count int64
+	result := DB.Model(&models.SeenVideo{}).Where("subscription_id = ? AND video_id = ?", subscriptionID, videoID).Count(&count)
+	return count > 0, result.Error
+}
+
+// MarkVideoSeen records a video for a subscription. It reports true only the
+// first time a video is seen.
+func MarkVideoSeen(subscriptionID uint, videoID string) (bool, error) {
+	seen := models.SeenVideo{SubscriptionID: subscriptionID, VideoID: videoID, SeenAt: time.Now()}
+	result := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&seen)
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetCachedResolution returns the cache entry for a video and itag if it is
+// still valid at validUntil.
+func GetCachedResolution(videoID string, itag int, validUntil time.Time) (*models.ResolutionCacheEntry, error) {
+	var entry models.ResolutionCacheEntry
+	result := DB.Where("video_id = ? AND itag = ? AND expires_at > ?", videoID, itag, validUntil).First(&entry)
+	return &entry, result.Error
+}
+
+func SaveCachedResolution(entry *models.ResolutionCacheEntry) error {
+	return DB.Save(entry).Error
+}
+
+func DeleteCachedResolution(videoID string, itag int) error {
+	return DB.Where("video_id = ? AND itag = ?", videoID, itag).Delete(&models.ResolutionCacheEntry{}).Error
+}
+
+func DeleteExpiredResolutions() error {
+	return DB.Where("expires_at < ?", time.Now()).Delete(&models.ResolutionCacheEntry{}).Error
+}
+
+func GetAPIUsage(keyID string, day time.Time) (int, error) {
+	var usage models.APIUsage
+	result := DB.Where("key_id = ? AND day = ?", keyID, day).Limit(1).Find(&usage)
+	return usage.Requests, result.Error
+}
+
+// IncrementAPIUsage adds one request to the counter for a key and day.
+func IncrementAPIUsage(keyID string, day time.Time) error {
+	usage := models.APIUsage{KeyID: keyID, Day: day, Requests: 1}
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"requests": gorm.Expr("api_usage.requests + 1")}),
+	}).Create(&usage).Error
+}
+
+// LoadQueuedJobs lists conversions and direct downloads waiting in the job
+// queue, oldest first.
+func LoadQueuedJobs() ([]models.QueuedJob, error) {
+	var jobs []models.QueuedJob
+	result := DB.Raw(`
+		SELECT id, ? AS kind, queued_at FROM conversion_jobs WHERE status = 'queued'
+		UNION ALL
+		SELECT id, ? AS kind, queued_at FROM direct_downloads WHERE status = 'queued'
+		ORDER BY queued_at, id`,
+		models.QueueKindConversion, models.QueueKindDownload,
+	).Scan(&jobs)
+	return jobs, result.Error
+}
+
+// LoadInFlightJobs lists conversions and downloads saved with one of the
+// given statuses, such as jobs that were running when the process stopped.
+// QueuedAt is left empty.
+func LoadInFlightJobs(statuses []string) ([]models.QueuedJob, error) {
+	var jobs []models.QueuedJob
+	result := DB.Raw(`
+		SELECT id, ? AS kind FROM conversion_jobs WHERE status IN ?
+		UNION ALL
+		SELECT id, ? AS kind FROM direct_downloads WHERE status IN ?
+		ORDER BY id`,
+		models.QueueKindConversion, statuses, models.QueueKindDownload, statuses,
+	).Scan(&jobs)
+	return jobs, result.Error
+}
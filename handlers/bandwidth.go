@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vicradon/yt-downloader/services"
+)
+
+type BandwidthHandler struct {
+	limiter *services.BandwidthLimiter
+}
+
+func NewBandwidthHandler(limiter *services.BandwidthLimiter) *BandwidthHandler {
+	return &BandwidthHandler{
+		limiter: limiter,
+	}
+}
+
+// ServeHTTP shows the bandwidth limits on GET and replaces them on PUT.
+// Running downloads switch to the new limits straight away.
+func (h *BandwidthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var limits services.BandwidthLimits
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := h.limiter.SetLimits(limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limits := h.limiter.Limits()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"bytesPerSecond":       limits.BytesPerSecond,
+		"schedule":             limits.Schedule,
+		"activeBytesPerSecond": h.limiter.ActiveLimit(),
+	})
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/vicradon/yt-downloader/services"
+)
+
+type BatchesHandler struct {
+	batchService *services.BatchService
+}
+
+func NewBatchesHandler(batchService *services.BatchService) *BatchesHandler {
+	return &BatchesHandler{
+		batchService: batchService,
+	}
+}
+
+// ServeHTTP lists all batches on /api/batches and returns a single batch
+// with its jobs on /api/batches/{id}.
+func (h *BatchesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	batchID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/batches"), "/")
+
+	if batchID == "" {
+		batches, err := h.batchService.GetAllBatches()
+		if err != nil {
+			http.Error(w, "Failed to load batches", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(batches)
+		return
+	}
+
+	batch, err := h.batchService.GetBatch(batchID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
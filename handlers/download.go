@@ -3,9 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/vicradon/yt-downloader/models"
@@ -13,14 +11,18 @@ import (
 )
 
 type DownloadHandler struct {
-	youtubeService         *services.YouTubeService
-	conversionService      *services.ConversionService
-	directDownloadService  *services.DirectDownloadService
+	sources               *services.SourceRegistry
+	mediaURLs             *services.MediaURLService
+	conversionService     *services.ConversionService
+	directDownloadService *services.DirectDownloadService
+	batchService          *services.BatchService
 }
 
-func NewDownloadHandler(youtubeService *services.YouTubeService, conversionService *services.ConversionService, directDownloadService *services.DirectDownloadService) *DownloadHandler {
+func NewDownloadHandler(sources *services.SourceRegistry, mediaURLs *services.MediaURLService, conversionService *services.ConversionService, directDownloadService *services.DirectDownloadService, batchService *services.BatchService) *DownloadHandler {
 	return &DownloadHandler{
-		youtubeService:        youtubeService,
+		sources:               sources,
+		mediaURLs:             mediaURLs,
+		batchService:          batchService,
 		conversionService:     conversionService,
 		directDownloadService: directDownloadService,
 	}
@@ -43,78 +45,117 @@ func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	videoID, err := h.youtubeService.ExtractVideoID(req.URL)
-	if err != nil {
-		http.Error(w, "Invalid YouTube URL: "+err.Error(), http.StatusBadRequest)
+	if req.Segments < 0 || req.Segments > services.MaxDownloadSegments {
+		http.Error(w, fmt.Sprintf("Segments must be between 0 and %d, 0 for the server default", services.MaxDownloadSegments), http.StatusBadRequest)
 		return
 	}
 
-	rapidResp, err := h.youtubeService.GetDownloadURL(videoID)
-	if err != nil {
-		http.Error(w, "Failed to get download URL: "+err.Error(), http.StatusInternalServerError)
+	if req.MaxBytesPerSecond < 0 {
+		http.Error(w, "maxBytesPerSecond can't be negative", http.StatusBadRequest)
 		return
 	}
 
-	log.Println("Waiting 20 seconds for file to be ready...")
-	h.youtubeService.WaitForFileReady()
-	log.Println("File should be ready now")
-
-	// Fetch video title for filename
-	videoTitle := rapidResp.Title
-	if videoTitle == "" {
-		if title, err := h.youtubeService.GetVideoTitle(videoID); err == nil {
-			videoTitle = title
-		} else {
-			log.Printf("Warning: could not fetch video title: %v", err)
-			videoTitle = videoID
+	// Playlist links are expanded into one job per video
+	if playlistSource, playlistID, ok := h.sources.LookupPlaylist(req.URL); ok {
+		if quotaExhausted(playlistSource) {
+			writeProviderError(w, "Cannot expand playlist", services.ErrQuotaExceeded, http.StatusTooManyRequests)
+			return
 		}
-	}
 
-	if !req.Convert {
-		// Sanitize filename and add .mp4 extension
-		sanitizedTitle := sanitizeFilename(videoTitle)
-		if sanitizedTitle == "" {
-			sanitizedTitle = videoID
+		batch, jobIDs, err := h.batchService.CreateBatch(playlistSource, playlistID, req)
+		if err != nil {
+			writeProviderError(w, "Failed to expand playlist", err, http.StatusBadGateway)
+			return
 		}
-		filename := sanitizedTitle + ".mp4"
 
-		// Create direct download record
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  batch.Status,
+			"batchId": batch.ID,
+			"jobIds":  jobIDs,
+		})
+		return
+	}
+
+	// Direct links to media files skip the video sources entirely
+	if h.mediaURLs.Accepts(req.URL) {
+		h.serveMediaURL(w, req)
+		return
+	}
+
+	source, videoID, err := h.sources.Lookup(req.URL)
+	if err != nil {
+		writeProviderError(w, "Invalid video URL", err, http.StatusBadRequest)
+		return
+	}
+
+	if quotaExhausted(source) {
+		writeProviderError(w, "Cannot resolve video", services.ErrQuotaExceeded, http.StatusTooManyRequests)
+		return
+	}
+
+	// Resolution can take a while (provider call, file generation), so the
+	// record is created straight away and resolved in the background.
+	if !req.Convert {
 		downloadID := fmt.Sprintf("%s_%d", videoID, time.Now().Unix())
-		download := h.directDownloadService.CreateDownload(downloadID, req.URL, filename)
+		download := h.directDownloadService.CreateDownload(downloadID, req.URL, "", req.Segments, req.MaxBytesPerSecond)
 
-		// Process download in background
-		go h.directDownloadService.ProcessDownload(download, rapidResp.File)
+		h.directDownloadService.Enqueue(download, req.Quality)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{
-			"status": "processing",
+			"status": download.Status,
 			"id":     downloadID,
 		})
 		return
 	}
 
 	jobID := fmt.Sprintf("%s_%d", videoID, time.Now().Unix())
-	job := h.conversionService.CreateJob(jobID, req.URL, req.Format, rapidResp.File, videoTitle)
+	job := h.conversionService.CreateJob(jobID, req.URL, req.Format, "", req.Segments, req.MaxBytesPerSecond)
 
-	go h.conversionService.ProcessConversion(job, rapidResp.File, req.Format, videoTitle)
+	h.conversionService.Enqueue(job, req.Quality)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"status": "converting",
+		"status": job.Status,
 		"jobId":  jobID,
 	})
 }
 
-func sanitizeFilename(filename string) string {
-	// Remove invalid characters for filenames
-	invalid := []string{"/", "\\", ":", "*", "?", "\"", "<", ">", "|"}
-	result := filename
-	for _, char := range invalid {
-		result = strings.ReplaceAll(result, char, "")
+func (h *DownloadHandler) serveMediaURL(w http.ResponseWriter, req models.DownloadRequest) {
+	probe, err := h.mediaURLs.Probe(req.URL)
+	if err != nil {
+		writeProviderError(w, "Cannot fetch media URL", err, http.StatusBadRequest)
+		return
 	}
-	// Limit length
-	if len(result) > 200 {
-		result = result[:200]
+
+	id := fmt.Sprintf("%s_%d", probe.ID, time.Now().Unix())
+
+	if !req.Convert {
+		download := h.directDownloadService.CreateDownload(id, probe.URL, "", req.Segments, req.MaxBytesPerSecond)
+		h.directDownloadService.EnqueueMediaURL(download, probe)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": download.Status,
+			"id":     id,
+		})
+		return
 	}
-	return strings.TrimSpace(result)
+
+	job := h.conversionService.CreateJob(id, probe.URL, req.Format, "", req.Segments, req.MaxBytesPerSecond)
+	h.conversionService.EnqueueMediaURL(job, probe)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": job.Status,
+		"jobId":  id,
+	})
+}
+
+// quotaExhausted reports whether a metered source has run out of quota, so
+// new submissions can be refused up front instead of failing later.
+func quotaExhausted(source interface{}) bool {
+	guard, ok := source.(services.QuotaGuard)
+	return ok && guard.QuotaExhausted()
 }
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/vicradon/yt-downloader/services"
+)
+
+// DownloadsHandler reports the status and progress of direct downloads.
+type DownloadsHandler struct {
+	directDownloadService *services.DirectDownloadService
+}
+
+func NewDownloadsHandler(directDownloadService *services.DirectDownloadService) *DownloadsHandler {
+	return &DownloadsHandler{
+		directDownloadService: directDownloadService,
+	}
+}
+
+// ServeHTTP handles GET /api/downloads and GET /api/downloads/{id}.
+func (h *DownloadsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/downloads"), "/")
+	if id == "" {
+		writeJSON(w, http.StatusOK, h.directDownloadService.GetAllDownloads())
+		return
+	}
+
+	download, err := h.directDownloadService.DownloadStatus(id)
+	if errors.Is(err, services.ErrJobNotFound) {
+		http.Error(w, "Download not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, download)
+}
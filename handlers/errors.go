@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/vicradon/yt-downloader/services"
+)
+
+// errorStatuses maps provider error codes to the HTTP status we answer with.
+var errorStatuses = map[string]int{
+	services.ErrorCodeInvalidID:         http.StatusBadRequest,
+	services.ErrorCodeUnavailable:       http.StatusNotFound,
+	services.ErrorCodeAgeRestricted:     http.StatusForbidden,
+	services.ErrorCodeQuotaExhausted:    http.StatusTooManyRequests,
+	services.ErrorCodeUpstream:          http.StatusBadGateway,
+	services.ErrorCodeMalformedResponse: http.StatusBadGateway,
+}
+
+// writeProviderError answers with the status matching err's code, or
+// fallback for untyped errors. The body carries the code so clients can
+// explain the failure.
+func writeProviderError(w http.ResponseWriter, message string, err error, fallback int) {
+	code := services.ErrorCode(err)
+	status, ok := errorStatuses[code]
+	if !ok {
+		status = fallback
+	}
+
+	writeJSON(w, status, map[string]interface{}{
+		"error":     message + ": " + err.Error(),
+		"errorCode": code,
+		"message":   services.ErrorMessage(code),
+		"retryable": services.Retryable(code),
+	})
+}
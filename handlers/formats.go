@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vicradon/yt-downloader/services"
+)
+
+type FormatsHandler struct {
+	sources *services.SourceRegistry
+}
+
+func NewFormatsHandler(sources *services.SourceRegistry) *FormatsHandler {
+	return &FormatsHandler{
+		sources: sources,
+	}
+}
+
+func (h *FormatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+
+	source, videoID, err := h.sources.Lookup(url)
+	if err != nil {
+		writeProviderError(w, "Invalid video URL", err, http.StatusBadRequest)
+		return
+	}
+
+	formats, err := source.Formats(videoID)
+	if err != nil {
+		writeProviderError(w, "Failed to list formats", err, http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"videoId": videoID,
+		"source":  source.Name(),
+		"formats": formats,
+	})
+}
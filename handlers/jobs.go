@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/vicradon/yt-downloader/services"
+)
+
+// JobsHandler runs actions on a conversion or direct download by ID.
+type JobsHandler struct {
+	conversionService     *services.ConversionService
+	directDownloadService *services.DirectDownloadService
+}
+
+func NewJobsHandler(conversionService *services.ConversionService, directDownloadService *services.DirectDownloadService) *JobsHandler {
+	return &JobsHandler{
+		conversionService:     conversionService,
+		directDownloadService: directDownloadService,
+	}
+}
+
+// ServeHTTP handles POST /api/jobs/{id}/cancel, /pause and /resume.
+func (h *JobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	id, action := parts[0], parts[1]
+
+	// A conversion and a download of the same video can be started in the
+	// same second and get the same ID, and then it's unclear which is meant
+	_, isConversion := h.conversionService.GetJob(id)
+	isDownload := h.directDownloadService.DownloadExists(id)
+
+	var cancel, pause, resume func(id string) error
+	switch {
+	case isConversion && isDownload:
+		http.Error(w, "Both a conversion and a download have this ID", http.StatusConflict)
+		return
+	case isConversion:
+		cancel, pause, resume = h.conversionService.CancelJob, h.conversionService.PauseJob, h.conversionService.ResumeJob
+	case isDownload:
+		cancel, pause, resume = h.directDownloadService.CancelDownload, h.directDownloadService.PauseDownload, h.directDownloadService.ResumeDownload
+	default:
+		http.Error(w, services.ErrJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	var err error
+	var status string
+	switch action {
+	case "cancel":
+		err = cancel(id)
+		status = services.StatusCancelled
+	case "pause":
+		err = pause(id)
+		status = services.StatusPaused
+	case "resume":
+		err = resume(id)
+		status = "queued"
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"status": status,
+	})
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vicradon/yt-downloader/services"
+)
+
+type QuotaHandler struct {
+	sources *services.SourceRegistry
+}
+
+func NewQuotaHandler(sources *services.SourceRegistry) *QuotaHandler {
+	return &QuotaHandler{
+		sources: sources,
+	}
+}
+
+func (h *QuotaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.sources.Quotas())
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"github.com/vicradon/yt-downloader/services"
 )
@@ -29,6 +30,10 @@ func (h *RetryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err := h.conversionService.RetryJob(jobID)
+	if errors.Is(err, services.ErrJobNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vicradon/yt-downloader/models"
+	"github.com/vicradon/yt-downloader/services"
+)
+
+type SubscriptionsHandler struct {
+	subscriptionService *services.SubscriptionService
+}
+
+func NewSubscriptionsHandler(subscriptionService *services.SubscriptionService) *SubscriptionsHandler {
+	return &SubscriptionsHandler{
+		subscriptionService: subscriptionService,
+	}
+}
+
+// ServeHTTP handles /api/subscriptions (GET list, POST create) and
+// /api/subscriptions/{id} (GET, PUT, DELETE).
+func (h *SubscriptionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	idPart := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/subscriptions"), "/")
+
+	if idPart == "" {
+		switch r.Method {
+		case http.MethodGet:
+			subscriptions, err := h.subscriptionService.GetAllSubscriptions()
+			if err != nil {
+				http.Error(w, "Failed to load subscriptions", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, subscriptions)
+		case http.MethodPost:
+			var req models.SubscriptionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			subscription, err := h.subscriptionService.CreateSubscription(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusCreated, subscription)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subscription, err := h.subscriptionService.GetSubscription(uint(id))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, subscription)
+	case http.MethodPut:
+		var req models.SubscriptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		subscription, err := h.subscriptionService.UpdateSubscription(uint(id), req)
+		if errors.Is(err, services.ErrSubscriptionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, subscription)
+	case http.MethodDelete:
+		if err := h.subscriptionService.DeleteSubscription(uint(id)); err != nil {
+			http.Error(w, "Failed to delete subscription", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vicradon/yt-downloader/services"
+	"github.com/vicradon/yt-downloader/utils"
+)
+
+type UploadHandler struct {
+	conversionService *services.ConversionService
+	maxSize           int64
+}
+
+func NewUploadHandler(conversionService *services.ConversionService, maxSize int64) *UploadHandler {
+	return &UploadHandler{
+		conversionService: conversionService,
+		maxSize:           maxSize,
+	}
+}
+
+// ServeHTTP reads a multipart form with a "format" field and a "file" part.
+// The file is streamed to disk as it arrives instead of being buffered, so
+// the format has to come before it.
+func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart upload", http.StatusBadRequest)
+		return
+	}
+
+	jobID := fmt.Sprintf("upload_%d", time.Now().UnixNano())
+	format := "mpg"
+	var filename, inputPath string
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			os.Remove(inputPath)
+			uploadError(w, err)
+			return
+		}
+
+		switch part.FormName() {
+		case "format":
+			if inputPath != "" {
+				os.Remove(inputPath)
+				http.Error(w, "The format field must come before the file", http.StatusBadRequest)
+				return
+			}
+			value, _ := io.ReadAll(io.LimitReader(part, 16))
+			if v := strings.ToLower(strings.TrimSpace(string(value))); v != "" {
+				format = v
+			}
+			if !utils.IsConversionFormat(format) {
+				http.Error(w, "Unsupported format: "+format, http.StatusBadRequest)
+				return
+			}
+		case "file":
+			if inputPath != "" {
+				os.Remove(inputPath)
+				http.Error(w, "Only one file can be uploaded at a time", http.StatusBadRequest)
+				return
+			}
+			filename = part.FileName()
+			inputPath, err = h.conversionService.SaveUpload(jobID, filename, part)
+			if err != nil {
+				uploadError(w, err)
+				return
+			}
+		}
+		part.Close()
+	}
+
+	if inputPath == "" {
+		http.Error(w, "File is required", http.StatusBadRequest)
+		return
+	}
+
+	job := h.conversionService.CreateUploadJob(jobID, filename, format)
+	h.conversionService.EnqueueUpload(job)
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status": job.Status,
+		"jobId":  jobID,
+	})
+}
+
+func uploadError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, fmt.Sprintf("File is larger than %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Upload failed: "+err.Error(), http.StatusBadRequest)
+}
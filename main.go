@@ -5,10 +5,12 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"time"
 
 	"github.com/vicradon/yt-downloader/config"
 	"github.com/vicradon/yt-downloader/database"
 	"github.com/vicradon/yt-downloader/handlers"
+	"github.com/vicradon/yt-downloader/models"
 	"github.com/vicradon/yt-downloader/services"
 )
 
@@ -24,53 +26,149 @@ func main() {
 	}
 
 	// Initialize services
-	youtubeService := services.NewYouTubeService(
-		config.AppConfig.RapidAPIKey,
-		config.AppConfig.RapidAPIHost,
+	youtubeService := services.NewYouTubeServiceWithKeys(services.NewKeyPool(
+		config.AppConfig.RapidAPIKeys,
+		config.AppConfig.RapidAPIHosts,
+		config.AppConfig.RapidAPIKeyStrategy,
+		config.AppConfig.RapidAPIKeyCooldown,
+	))
+
+	youtubeService.CacheTTL = config.AppConfig.ResolutionCacheTTL
+	youtubeService.DailyBudget = config.AppConfig.RapidAPIDailyBudget
+
+	providers := map[string]services.VideoSource{"rapidapi": youtubeService}
+	if config.AppConfig.InvidiousHost != "" {
+		invidiousSource := services.NewInvidiousSource(config.AppConfig.InvidiousHost)
+		providers["invidious"] = invidiousSource
+		youtubeService.Playlists = invidiousSource
+	}
+	resolverChain := services.NewResolverChain(
+		config.AppConfig.ResolverChain,
+		providers,
+		config.AppConfig.ResolverBreakerLimit,
+		config.AppConfig.ResolverBreakerCooldown,
+	)
+
+	sourceRegistry := services.NewSourceRegistry(resolverChain)
+
+	mediaURLService := services.NewMediaURLService(
+		config.AppConfig.MediaURLAllowlist,
+		config.AppConfig.MediaURLDenylist,
+		config.AppConfig.MediaURLMaxSize,
 	)
 
+	readinessProber := services.NewReadinessProber(config.AppConfig.FileReadyTimeout)
+
 	storageService := services.NewStorageService(config.AppConfig.AbsCompletedDir)
 
+	jobQueue := services.NewJobQueue(config.AppConfig.DownloadWorkers, config.AppConfig.TranscodeWorkers)
+
 	conversionService := services.NewConversionService(
 		config.AppConfig.AbsOngoingDir,
 		config.AppConfig.AbsCompletedDir,
 		storageService,
+		readinessProber,
+		sourceRegistry,
+		jobQueue,
 	)
 
 	directDownloadService := services.NewDirectDownloadService(
 		config.AppConfig.AbsOngoingDir,
 		config.AppConfig.AbsCompletedDir,
+		readinessProber,
+		sourceRegistry,
+		jobQueue,
 	)
 
+	conversionService.DownloadSegments = config.AppConfig.DownloadSegments
+	directDownloadService.DownloadSegments = config.AppConfig.DownloadSegments
+
+	bandwidthSchedule, err := services.ParseBandwidthSchedule(config.AppConfig.BandwidthSchedule)
+	if err != nil {
+		log.Printf("Warning: Ignoring BANDWIDTH_SCHEDULE: %v", err)
+	}
+	bandwidthLimiter := services.NewBandwidthLimiter(config.AppConfig.BandwidthLimit, bandwidthSchedule)
+	conversionService.Bandwidth = bandwidthLimiter
+	directDownloadService.Bandwidth = bandwidthLimiter
+	conversionService.MediaURLs = mediaURLService
+	directDownloadService.MediaURLs = mediaURLService
+
+	jobQueue.Register(models.QueueKindConversion, conversionService)
+	jobQueue.Register(models.QueueKindDownload, directDownloadService)
+
+	batchService := services.NewBatchService(sourceRegistry, conversionService, directDownloadService)
+
+	subscriptionService := services.NewSubscriptionService(
+		sourceRegistry,
+		conversionService,
+		directDownloadService,
+		config.AppConfig.SubscriptionPollInterval,
+	)
+
+	services.PruneResolutionCache(time.Hour)
+
 	// Load existing conversions from database
 	if err := conversionService.LoadFromDatabase(); err != nil {
 		log.Printf("Warning: Failed to load conversions from database: %v", err)
 	}
 
+	// Jobs that were running when the server stopped are queued again or
+	// marked interrupted
+	reconciler := services.NewReconciler(conversionService, directDownloadService, config.AppConfig.AbsOngoingDir)
+	if err := reconciler.Run(); err != nil {
+		log.Printf("Warning: Failed to recover interrupted jobs: %v", err)
+	}
+
+	// Jobs still queued from before a restart run first
+	if err := jobQueue.Restore(); err != nil {
+		log.Printf("Warning: Failed to restore job queue: %v", err)
+	}
+
+	subscriptionService.Start()
+
 	// Initialize handlers
 	indexHandler := handlers.NewIndexHandler(config.AppConfig.ExecDir)
 	conversionsPageHandler := handlers.NewConversionsPageHandler(config.AppConfig.ExecDir)
-	downloadHandler := handlers.NewDownloadHandler(youtubeService, conversionService, directDownloadService)
+	downloadHandler := handlers.NewDownloadHandler(sourceRegistry, mediaURLService, conversionService, directDownloadService, batchService)
 	conversionsHandler := handlers.NewConversionsHandler(conversionService)
 	fileHandler := handlers.NewFileHandler(storageService)
 	deleteHandler := handlers.NewDeleteHandler(storageService)
 	retryHandler := handlers.NewRetryHandler(conversionService)
+	uploadHandler := handlers.NewUploadHandler(conversionService, config.AppConfig.UploadMaxSize)
+	formatsHandler := handlers.NewFormatsHandler(sourceRegistry)
+	batchesHandler := handlers.NewBatchesHandler(batchService)
+	subscriptionsHandler := handlers.NewSubscriptionsHandler(subscriptionService)
+	quotaHandler := handlers.NewQuotaHandler(sourceRegistry)
+	bandwidthHandler := handlers.NewBandwidthHandler(bandwidthLimiter)
+	jobsHandler := handlers.NewJobsHandler(conversionService, directDownloadService)
+	downloadsHandler := handlers.NewDownloadsHandler(directDownloadService)
 	directDownloadFileHandler := handlers.NewDirectDownloadFileHandler(directDownloadService, config.AppConfig.AbsCompletedDir)
 
 	// Register static files
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(filepath.Join(config.AppConfig.ExecDir, "static")))))
-	
+
 	// Register page routes
 	http.Handle("/", indexHandler)
 	http.Handle("/conversions", conversionsPageHandler)
-	
+
 	// Register API routes with /api prefix
 	http.Handle("/api/download", downloadHandler)
 	http.Handle("/api/file/", fileHandler)
 	http.Handle("/api/conversions", conversionsHandler)
 	http.Handle("/api/delete/", deleteHandler)
 	http.Handle("/api/retry/", retryHandler)
+	http.Handle("/api/upload", uploadHandler)
 	http.Handle("/api/direct-download/", directDownloadFileHandler)
+	http.Handle("/api/downloads", downloadsHandler)
+	http.Handle("/api/downloads/", downloadsHandler)
+	http.Handle("/api/formats", formatsHandler)
+	http.Handle("/api/batches", batchesHandler)
+	http.Handle("/api/batches/", batchesHandler)
+	http.Handle("/api/subscriptions", subscriptionsHandler)
+	http.Handle("/api/subscriptions/", subscriptionsHandler)
+	http.Handle("/api/provider/quota", quotaHandler)
+	http.Handle("/api/admin/bandwidth", bandwidthHandler)
+	http.Handle("/api/jobs/", jobsHandler)
 
 	fmt.Println("Server starting on http://0.0.0.0:8080")
 	log.Fatal(http.ListenAndServe("0.0.0.0:8080", nil))
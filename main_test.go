@@ -1,12 +1,31 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/vicradon/yt-downloader/database"
+	"github.com/vicradon/yt-downloader/handlers"
+	"github.com/vicradon/yt-downloader/models"
 	"github.com/vicradon/yt-downloader/services"
 	"github.com/vicradon/yt-downloader/utils"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 func TestVideoConversion(t *testing.T) {
@@ -54,7 +73,7 @@ func TestVideoConversion(t *testing.T) {
 			outputFile := filepath.Join(outputDir, tt.name+"."+tt.outputExt)
 
 			// Use the buildFFmpegCommand function from utils package
-			cmd := utils.BuildFFmpegCommand(testVideo, outputFile, tt.format)
+			cmd := utils.BuildFFmpegCommand(context.Background(), testVideo, outputFile, tt.format)
 
 			output, err := cmd.CombinedOutput()
 			if err != nil {
@@ -77,6 +96,55 @@ func TestVideoConversion(t *testing.T) {
 	}
 }
 
+func TestPublishFileRejectsTruncatedMedia(t *testing.T) {
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found, skipping test", tool)
+		}
+	}
+
+	// The index sits at the front, so a cut-off copy still probes fine
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.mp4")
+	cmd := exec.Command("ffmpeg", "-v", "error", "-f", "lavfi", "-i", "testsrc=duration=10:size=320x240:rate=25",
+		"-f", "lavfi", "-i", "sine=duration=10", "-c:v", "mpeg4", "-c:a", "aac", "-movflags", "+faststart", source)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to create test video: %v\n%s", err, output)
+	}
+	content, err := os.ReadFile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		size    int
+		wantErr error
+	}{
+		{"Intact file", len(content), nil},
+		{"Truncated file", len(content) / 2, services.ErrCorruptFile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempPath := filepath.Join(dir, "temp.mp4")
+			finalPath := filepath.Join(dir, "final.mp4")
+			defer os.Remove(finalPath)
+			if err := os.WriteFile(tempPath, content[:tt.size], 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := services.PublishFile(tempPath, finalPath)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PublishFile() error = %v, want %v", err, tt.wantErr)
+			}
+			if _, statErr := os.Stat(finalPath); (statErr == nil) != (tt.wantErr == nil) {
+				t.Errorf("published = %v, want %v", statErr == nil, tt.wantErr == nil)
+			}
+		})
+	}
+}
+
 func TestExtractVideoID(t *testing.T) {
 	// Create a YouTube service for testing
 	ytService := services.NewYouTubeService("test-key", "test-host")
@@ -105,6 +173,54 @@ func TestExtractVideoID(t *testing.T) {
 			want:    "dEXPMQXoiLc",
 			wantErr: false,
 		},
+		{
+			name:    "YouTube URL with v not first",
+			url:     "https://www.youtube.com/watch?feature=share&v=dEXPMQXoiLc#t=30",
+			want:    "dEXPMQXoiLc",
+			wantErr: false,
+		},
+		{
+			name:    "Shorts URL",
+			url:     "https://youtube.com/shorts/dEXPMQXoiLc?si=abc",
+			want:    "dEXPMQXoiLc",
+			wantErr: false,
+		},
+		{
+			name:    "Embed URL on nocookie host",
+			url:     "https://www.youtube-nocookie.com/embed/dEXPMQXoiLc",
+			want:    "dEXPMQXoiLc",
+			wantErr: false,
+		},
+		{
+			name:    "Live URL",
+			url:     "https://www.youtube.com/live/dEXPMQXoiLc",
+			want:    "dEXPMQXoiLc",
+			wantErr: false,
+		},
+		{
+			name:    "Mobile URL",
+			url:     "https://m.youtube.com/watch?v=dEXPMQXoiLc",
+			want:    "dEXPMQXoiLc",
+			wantErr: false,
+		},
+		{
+			name:    "Music URL without scheme",
+			url:     "music.youtube.com/watch?v=dEXPMQXoiLc",
+			want:    "dEXPMQXoiLc",
+			wantErr: false,
+		},
+		{
+			name:    "Legacy /v/ URL",
+			url:     "https://www.youtube.com/v/dEXPMQXoiLc",
+			want:    "dEXPMQXoiLc",
+			wantErr: false,
+		},
+		{
+			name:    "ID with wrong length",
+			url:     "https://youtu.be/dEXPMQ",
+			want:    "",
+			wantErr: true,
+		},
 		{
 			name:    "Invalid URL",
 			url:     "https://example.com/video",
@@ -127,6 +243,33 @@ func TestExtractVideoID(t *testing.T) {
 	}
 }
 
+func TestParseYouTubeURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		wantID    string
+		wantStart int
+		wantList  string
+	}{
+		{"Query start time", "https://www.youtube.com/watch?v=dEXPMQXoiLc&t=1m30s", "dEXPMQXoiLc", 90, ""},
+		{"Fragment start time", "https://youtu.be/dEXPMQXoiLc#t=45", "dEXPMQXoiLc", 45, ""},
+		{"Video in playlist", "https://www.youtube.com/watch?v=dEXPMQXoiLc&list=PL1234567890", "dEXPMQXoiLc", 0, "PL1234567890"},
+		{"Playlist only", "https://www.youtube.com/playlist?list=PL1234567890", "", 0, "PL1234567890"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := services.ParseYouTubeURL(tt.url)
+			if err != nil {
+				t.Fatalf("ParseYouTubeURL() error = %v", err)
+			}
+			if got.VideoID != tt.wantID || got.StartSeconds != tt.wantStart || got.PlaylistID != tt.wantList {
+				t.Errorf("ParseYouTubeURL() = %+v, want id=%s start=%d list=%s", got, tt.wantID, tt.wantStart, tt.wantList)
+			}
+		})
+	}
+}
+
 func TestFormatFileSize(t *testing.T) {
 	// Create a storage service for testing
 	storageService := services.NewStorageService("/tmp")
@@ -151,3 +294,1201 @@ func TestFormatFileSize(t *testing.T) {
 		})
 	}
 }
+
+type fakeSource struct {
+	name   string
+	prefix string
+	err    error
+	calls  int
+}
+
+func (f *fakeSource) Name() string { return f.name }
+
+func (f *fakeSource) ExtractVideoID(url string) (string, error) {
+	if !strings.HasPrefix(url, f.prefix) {
+		return "", fmt.Errorf("unsupported")
+	}
+	return strings.TrimPrefix(url, f.prefix), nil
+}
+
+func (f *fakeSource) Resolve(videoID string, itag int) (*models.MediaStream, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &models.MediaStream{Itag: itag, File: "https://cdn.example.com/" + videoID}, nil
+}
+
+func (f *fakeSource) Metadata(videoID string) (*models.VideoMetadata, error) {
+	return &models.VideoMetadata{ID: videoID, Title: "Fake " + videoID}, nil
+}
+
+func (f *fakeSource) Formats(videoID string) ([]models.MediaStream, error) {
+	return nil, nil
+}
+
+func TestSourceRegistryLookup(t *testing.T) {
+	registry := services.NewSourceRegistry(
+		services.NewYouTubeService("test-key", "test-host"),
+		&fakeSource{name: "fake", prefix: "fake://"},
+	)
+
+	tests := []struct {
+		name       string
+		url        string
+		wantSource string
+		wantID     string
+		wantErr    bool
+	}{
+		{"YouTube URL", "https://youtu.be/dEXPMQXoiLc", "rapidapi", "dEXPMQXoiLc", false},
+		{"Fake URL", "fake://abc", "fake", "abc", false},
+		{"Unsupported URL", "https://example.com/video", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, videoID, err := registry.Lookup(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Lookup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if source.Name() != tt.wantSource || videoID != tt.wantID {
+				t.Errorf("Lookup() = (%s, %s), want (%s, %s)", source.Name(), videoID, tt.wantSource, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestResolverChainFallback(t *testing.T) {
+	failing := &fakeSource{name: "failing", prefix: "fake://", err: fmt.Errorf("upstream down")}
+	healthy := &fakeSource{name: "healthy", prefix: "fake://"}
+
+	chain := services.NewResolverChain(
+		[]string{"failing", "healthy"},
+		map[string]services.VideoSource{"failing": failing, "healthy": healthy},
+		1,
+		time.Hour,
+	)
+
+	stream, err := chain.Resolve("abc", 247)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if stream.Provider != "healthy" {
+		t.Errorf("Resolve() provider = %s, want healthy", stream.Provider)
+	}
+
+	// The failing provider's breaker is now open, so it is skipped
+	if _, err := chain.Resolve("abc", 247); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if failing.calls != 1 {
+		t.Errorf("failing provider called %d times, want 1", failing.calls)
+	}
+	if states := chain.ProviderStates(); states["failing"] != services.BreakerOpen {
+		t.Errorf("failing provider state = %s, want %s", states["failing"], services.BreakerOpen)
+	}
+}
+
+func TestSelectFormat(t *testing.T) {
+	formats := []models.MediaStream{
+		{Itag: 140, Height: 0, Mime: `audio/mp4; codecs="mp4a.40.2"`, Bitrate: 128000},
+		{Itag: 136, Height: 720, Mime: `video/mp4; codecs="avc1.4d401f"`, Bitrate: 1500000},
+		{Itag: 247, Height: 720, Mime: `video/webm; codecs="vp9"`, Bitrate: 1200000},
+		{Itag: 137, Height: 1080, Mime: `video/mp4; codecs="avc1.640028"`, Bitrate: 4000000},
+		{Itag: 248, Height: 1080, Mime: `video/webm; codecs="vp9"`, Bitrate: 2500000},
+	}
+
+	tests := []struct {
+		name     string
+		quality  models.QualitySelection
+		wantItag int
+		wantErr  bool
+	}{
+		{"Highest available", models.QualitySelection{MaxHeight: 4320}, 137, false},
+		{"Capped at 720p", models.QualitySelection{MaxHeight: 720}, 136, false},
+		{"WebM container", models.QualitySelection{Container: "webm"}, 248, false},
+		{"VP9 up to 720p", models.QualitySelection{MaxHeight: 720, Codec: "vp9"}, 247, false},
+		{"No match", models.QualitySelection{MaxHeight: 240}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := services.SelectFormat(formats, tt.quality)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SelectFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Itag != tt.wantItag {
+				t.Errorf("SelectFormat() itag = %d, want %d", got.Itag, tt.wantItag)
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantCode  string
+		retryable bool
+	}{
+		{"Removed video", fmt.Errorf("rapidapi: %w", services.ErrVideoUnavailable), services.ErrorCodeUnavailable, false},
+		{"Quota", fmt.Errorf("%w: daily budget reached", services.ErrQuotaExceeded), services.ErrorCodeQuotaExhausted, true},
+		{"Joined chain errors", errors.Join(services.ErrUpstream, services.ErrAgeRestricted), services.ErrorCodeAgeRestricted, false},
+		{"Corrupt download", fmt.Errorf("%w: got 10 bytes, provider announced 20", services.ErrCorruptFile), services.ErrorCodeCorruptFile, true},
+		{"Interrupted", fmt.Errorf("Interrupted while converting: %w", services.ErrInterrupted), services.ErrorCodeInterrupted, true},
+		{"Untyped", fmt.Errorf("connection reset"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := services.ErrorCode(tt.err)
+			if code != tt.wantCode {
+				t.Errorf("ErrorCode() = %q, want %q", code, tt.wantCode)
+			}
+			if services.Retryable(code) != tt.retryable {
+				t.Errorf("Retryable(%q) = %v, want %v", code, !tt.retryable, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestMediaURLAccepts(t *testing.T) {
+	mediaURLs := services.NewMediaURLService(nil, nil, 0)
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://cdn.example.com/talks/keynote.mp4", true},
+		{"http://bucket.s3.amazonaws.com/video.mp4?X-Amz-Signature=abc", true},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"https://youtu.be/dQw4w9WgXcQ", false},
+		{"ftp://example.com/video.mp4", false},
+		{"not a url", false},
+	}
+
+	for _, tt := range tests {
+		if got := mediaURLs.Accepts(tt.url); got != tt.want {
+			t.Errorf("Accepts(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestTransferSnapshotFraction(t *testing.T) {
+	tests := []struct {
+		done, total int64
+		want        float64
+	}{
+		{0, 0, 0},
+		{50, 0, 0},
+		{25, 100, 0.25},
+		{100, 100, 1},
+		{120, 100, 1},
+	}
+
+	for _, tt := range tests {
+		s := services.TransferSnapshot{Done: tt.done, Total: tt.total}
+		if got := s.Fraction(); got != tt.want {
+			t.Errorf("Fraction() with %d of %d = %v, want %v", tt.done, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestParseBandwidthSchedule(t *testing.T) {
+	schedule, err := services.ParseBandwidthSchedule("9:00-18:00=2048, 22:00-06:00=0")
+	if err != nil {
+		t.Fatalf("ParseBandwidthSchedule() error = %v", err)
+	}
+	want := []services.BandwidthWindow{
+		{Start: "09:00", End: "18:00", BytesPerSecond: 2 << 20},
+		{Start: "22:00", End: "06:00", BytesPerSecond: 0},
+	}
+	if len(schedule) != len(want) {
+		t.Fatalf("got %d windows, want %d", len(schedule), len(want))
+	}
+	for i := range want {
+		if schedule[i] != want[i] {
+			t.Errorf("window %d = %+v, want %+v", i, schedule[i], want[i])
+		}
+	}
+
+	for _, spec := range []string{"09:00-18:00", "9am-6pm=100", "09:00=100", "09:00-18:00=-1"} {
+		if _, err := services.ParseBandwidthSchedule(spec); err == nil {
+			t.Errorf("ParseBandwidthSchedule(%q) expected an error", spec)
+		}
+	}
+}
+
+type blockingRunner struct {
+	started chan string
+	release chan struct{}
+}
+
+func (r *blockingRunner) RunQueued(id string) {
+	r.started <- id
+	<-r.release
+}
+
+func TestMediaURLJobFilenames(t *testing.T) {
+	useOfflineDatabase(t)
+
+	// The first job holds the only slot, so none of them starts downloading
+	runner := &blockingRunner{started: make(chan string, 1), release: make(chan struct{})}
+	defer close(runner.release)
+	queue := services.NewJobQueue(1, 1)
+	queue.Register(models.QueueKindConversion, runner)
+	queue.Register(models.QueueKindDownload, runner)
+
+	downloads := services.NewDirectDownloadService(t.TempDir(), t.TempDir(), nil, nil, queue)
+	conversions := services.NewConversionService(t.TempDir(), t.TempDir(), nil, nil, nil, queue)
+	probe := &services.MediaProbe{ID: "url_0123456789ab", URL: "https://cdn.example.com/video.mp4", Size: 10, Filename: "video.mp4"}
+
+	first := downloads.CreateDownload("url_0123456789ab_1", probe.URL, "", 0, 0)
+	downloads.EnqueueMediaURL(first, probe)
+	second := downloads.CreateDownload("url_0123456789ab_2", probe.URL, "", 0, 0)
+	downloads.EnqueueMediaURL(second, probe)
+	job := conversions.CreateJob("url_0123456789ab_3", probe.URL, "avi", "", 0, 0)
+	conversions.EnqueueMediaURL(job, probe)
+
+	names := []string{first.Filename, second.Filename, job.VideoTitle + ".mp4"}
+	want := []string{"video_url_0123456789ab_1.mp4", "video_url_0123456789ab_2.mp4", "video_url_0123456789ab_3.mp4"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("filenames = %v, want %v", names, want)
+	}
+}
+
+func TestThrottledDownloadStopsWithItsJob(t *testing.T) {
+	useOfflineDatabase(t)
+
+	content := bytes.Repeat([]byte("x"), 64<<10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "clip.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	downloads := services.NewDirectDownloadService(t.TempDir(), t.TempDir(), services.NewReadinessProber(10*time.Second), nil, services.NewJobQueue(1, 1))
+	// At 100 B/s even a small first read would wait for most of a minute
+	downloads.Bandwidth = services.NewBandwidthLimiter(100, nil)
+	download := downloads.CreateDownload("download", server.URL, "", 1, 0)
+	download.Filename = "clip.mp4"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		downloads.ProcessDownload(ctx, download, server.URL)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a throttled download kept waiting after its context ended")
+	}
+}
+
+func TestJobQueueOrder(t *testing.T) {
+	runner := &blockingRunner{started: make(chan string, 3), release: make(chan struct{})}
+	queue := services.NewJobQueue(1, 1)
+	queue.Register(models.QueueKindDownload, runner)
+
+	for _, id := range []string{"first", "second", "third"} {
+		queue.Submit(models.QueueKindDownload, id, time.Now())
+	}
+
+	if id := <-runner.started; id != "first" {
+		t.Fatalf("started %q, want first", id)
+	}
+	if pos := queue.Position(models.QueueKindDownload, "second"); pos != 1 {
+		t.Errorf("Position(second) = %d, want 1", pos)
+	}
+	if pos := queue.Position(models.QueueKindDownload, "third"); pos != 2 {
+		t.Errorf("Position(third) = %d, want 2", pos)
+	}
+
+	runner.release <- struct{}{}
+	if id := <-runner.started; id != "second" {
+		t.Fatalf("started %q, want second", id)
+	}
+	if pos := queue.Position(models.QueueKindDownload, "third"); pos != 1 {
+		t.Errorf("Position(third) = %d, want 1", pos)
+	}
+
+	runner.release <- struct{}{}
+	<-runner.started
+	runner.release <- struct{}{}
+}
+
+func TestJobQueueRemove(t *testing.T) {
+	runner := &blockingRunner{started: make(chan string, 3), release: make(chan struct{})}
+	queue := services.NewJobQueue(1, 1)
+	queue.Register(models.QueueKindDownload, runner)
+
+	for _, id := range []string{"first", "second", "third"} {
+		queue.Submit(models.QueueKindDownload, id, time.Now())
+	}
+	<-runner.started
+
+	if queue.Remove(models.QueueKindDownload, "first") {
+		t.Error("Remove(first) = true for a running job")
+	}
+	if !queue.Remove(models.QueueKindDownload, "second") {
+		t.Error("Remove(second) = false for a waiting job")
+	}
+	if pos := queue.Position(models.QueueKindDownload, "third"); pos != 1 {
+		t.Errorf("Position(third) = %d, want 1", pos)
+	}
+
+	runner.release <- struct{}{}
+	if id := <-runner.started; id != "third" {
+		t.Fatalf("started %q, want third", id)
+	}
+	runner.release <- struct{}{}
+}
+
+func TestReadinessProberBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		readyAfter int
+		deadline   time.Duration
+		wantState  string
+		wantProbes int
+	}{
+		// Probes at 0s, 1s and 3s as the delay doubles
+		{"Ready after retries", 2, 10 * time.Second, services.FileStateReady, 3},
+		// The second delay of 2s would pass the deadline
+		{"Deadline passes", 100, 1500 * time.Millisecond, services.FileStateTimedOut, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var probes int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				probes++
+				if probes <= tt.readyAfter {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Length", "10")
+			}))
+			defer server.Close()
+
+			var states []string
+			err := services.NewReadinessProber(tt.deadline).WaitUntilReady(context.Background(), []string{server.URL}, func(state string) {
+				states = append(states, state)
+			})
+
+			if (err != nil) != (tt.wantState == services.FileStateTimedOut) {
+				t.Fatalf("WaitUntilReady() error = %v", err)
+			}
+			if probes != tt.wantProbes {
+				t.Errorf("probed %d times, want %d", probes, tt.wantProbes)
+			}
+			if want := []string{services.FileStateWaiting, tt.wantState}; strings.Join(states, ",") != strings.Join(want, ",") {
+				t.Errorf("states = %v, want %v", states, want)
+			}
+		})
+	}
+}
+
+func TestStreamKind(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream models.MediaStream
+		want   string
+	}{
+		{"Audio mime", models.MediaStream{Itag: 140, Mime: `audio/mp4; codecs="mp4a.40.2"`}, services.StreamAudioOnly},
+		{"Video-only mime", models.MediaStream{Itag: 247, Mime: `video/webm; codecs="vp9"`}, services.StreamVideoOnly},
+		{"Muxed itag", models.MediaStream{Itag: 18, Mime: `video/mp4; codecs="avc1.42001E"`}, services.StreamMuxed},
+		{"Muxed codecs", models.MediaStream{Itag: 999, Mime: `video/mp4; codecs="avc1.42001E, mp4a.40.2"`}, services.StreamMuxed},
+		{"Audio itag without mime", models.MediaStream{Itag: 251}, services.StreamAudioOnly},
+		{"Video itag without mime", models.MediaStream{Itag: 137}, services.StreamVideoOnly},
+		{"Unknown itag without mime", models.MediaStream{Itag: 999}, services.StreamMuxed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := services.StreamKind(&tt.stream); got != tt.want {
+				t.Errorf("StreamKind() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractPlaylistID(t *testing.T) {
+	youtube := services.NewYouTubeService("test-key", "test-host")
+
+	tests := []struct {
+		url    string
+		wantID string
+		wantOK bool
+	}{
+		{"https://www.youtube.com/playlist?list=PL590L5WQmH8fJ54F369BLDSqIwcs-TCfs", "PL590L5WQmH8fJ54F369BLDSqIwcs-TCfs", true},
+		{"https://music.youtube.com/watch?list=OLAK5uy_k1", "OLAK5uy_k1", true},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL590L5WQmH8fJ54F369BLDSqIwcs-TCfs", "", false},
+		{"https://www.youtube.com/playlist?list=RDdQw4w9WgXcQ", "", false},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "", false},
+	}
+
+	for _, tt := range tests {
+		id, ok := youtube.ExtractPlaylistID(tt.url)
+		if id != tt.wantID || ok != tt.wantOK {
+			t.Errorf("ExtractPlaylistID(%q) = (%q, %v), want (%q, %v)", tt.url, id, ok, tt.wantID, tt.wantOK)
+		}
+	}
+}
+
+func TestInvidiousPlaylistPages(t *testing.T) {
+	// 130 videos over two pages of up to 100, the second one repeating the
+	// last video of the first
+	page := func(from, to int) string {
+		var videos []string
+		for i := from; i < to; i++ {
+			videos = append(videos, fmt.Sprintf(`{"title":"Video %d","videoId":"vid%03d","author":"Author"}`, i, i))
+		}
+		return fmt.Sprintf(`{"title":"Long playlist","videoCount":%d,"videos":[%s]}`, 130, strings.Join(videos, ","))
+	}
+
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/playlists/PLlong" {
+			http.NotFound(w, r)
+			return
+		}
+		requested = append(requested, r.URL.Query().Get("page"))
+		switch r.URL.Query().Get("page") {
+		case "1":
+			fmt.Fprint(w, page(0, 100))
+		case "2":
+			fmt.Fprint(w, page(99, 130))
+		default:
+			fmt.Fprint(w, page(0, 0))
+		}
+	}))
+	defer server.Close()
+
+	invidious := services.NewInvidiousSource(server.URL)
+	youtube := services.NewYouTubeService("test-key", "test-host")
+	youtube.Playlists = invidious
+
+	sources := []struct {
+		name   string
+		source services.PlaylistSource
+	}{
+		{"Invidious", invidious},
+		{"YouTube with Invidious", youtube},
+	}
+
+	for _, tt := range sources {
+		t.Run(tt.name, func(t *testing.T) {
+			requested = nil
+
+			title, videos, err := tt.source.PlaylistVideos("PLlong")
+			if err != nil {
+				t.Fatalf("PlaylistVideos() error = %v", err)
+			}
+			if title != "Long playlist" {
+				t.Errorf("title = %q, want %q", title, "Long playlist")
+			}
+			if len(videos) != 130 {
+				t.Fatalf("got %d videos, want 130", len(videos))
+			}
+			if videos[0].ID != "vid000" || videos[129].ID != "vid129" {
+				t.Errorf("videos run from %s to %s, want vid000 to vid129", videos[0].ID, videos[129].ID)
+			}
+			if strings.Join(requested, ",") != "1,2" {
+				t.Errorf("requested pages %v, want [1 2]", requested)
+			}
+		})
+	}
+
+	if _, _, err := invidious.PlaylistVideos("PLmissing"); err == nil {
+		t.Error("expanding a missing playlist should fail")
+	}
+}
+
+func TestSignedURLExpiry(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		want   time.Time
+		wantOK bool
+	}{
+		{"Signed URL", "https://rr1.googlevideo.com/videoplayback?expire=1760700000&itag=247", time.Unix(1760700000, 0), true},
+		{"No expire parameter", "https://cdn.example.com/video.mp4", time.Time{}, false},
+		{"Zero expiry", "https://rr1.googlevideo.com/videoplayback?expire=0", time.Time{}, false},
+		{"Malformed expiry", "https://rr1.googlevideo.com/videoplayback?expire=soon", time.Time{}, false},
+		{"Invalid URL", "://bad", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := services.SignedURLExpiry(tt.url)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("SignedURLExpiry() = (%v, %v), want (%v, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestKeyPoolCooldown(t *testing.T) {
+	pool := services.NewKeyPool([]string{"key-a", "key-b"}, []string{"host"}, services.KeyStrategyRoundRobin, 50*time.Millisecond)
+
+	first, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	pool.MarkRateLimited(first)
+
+	// Only the other key is handed out while the first one cools down
+	for i := 0; i < 3; i++ {
+		key, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		if key == first {
+			t.Fatalf("Acquire() returned the rate limited key %s during its cooldown", key.Key)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	var picked bool
+	for i := 0; i < 2; i++ {
+		key, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		picked = picked || key == first
+	}
+	if !picked {
+		t.Errorf("rate limited key %s wasn't picked again after its cooldown", first.Key)
+	}
+	if pool.Exhausted() {
+		t.Error("Exhausted() = true after every cooldown ended")
+	}
+}
+
+func TestResolverChainDemote(t *testing.T) {
+	first := &fakeSource{name: "first", prefix: "fake://"}
+	second := &fakeSource{name: "second", prefix: "fake://"}
+
+	chain := services.NewResolverChain(
+		[]string{"first", "second"},
+		map[string]services.VideoSource{"first": first, "second": second},
+		3,
+		time.Hour,
+	)
+	chain.Demote("abc", "first")
+
+	tests := []struct {
+		videoID      string
+		wantProvider string
+	}{
+		{"abc", "second"},
+		{"xyz", "first"},
+	}
+
+	for _, tt := range tests {
+		stream, err := chain.Resolve(tt.videoID, 247)
+		if err != nil {
+			t.Fatalf("Resolve(%s) error = %v", tt.videoID, err)
+		}
+		if stream.Provider != tt.wantProvider {
+			t.Errorf("Resolve(%s) provider = %s, want %s", tt.videoID, stream.Provider, tt.wantProvider)
+		}
+	}
+}
+
+func TestReadinessProberMirrorFallback(t *testing.T) {
+	var primaryProbes int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryProbes++
+		http.NotFound(w, r)
+	}))
+	defer primary.Close()
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+	}))
+	defer mirror.Close()
+
+	var states []string
+	files := [][]string{{primary.URL, mirror.URL}, {""}}
+	err := services.NewReadinessProber(10*time.Second).WaitUntilAllReady(context.Background(), files, func(state string) {
+		states = append(states, state)
+	})
+	if err != nil {
+		t.Fatalf("WaitUntilAllReady() error = %v", err)
+	}
+	if primaryProbes != 1 {
+		t.Errorf("probed primary %d times, want 1", primaryProbes)
+	}
+	if want := []string{services.FileStateWaiting, services.FileStateReady}; strings.Join(states, ",") != strings.Join(want, ",") {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+}
+
+func TestResolverChainBreakerRecovers(t *testing.T) {
+	source := &fakeSource{name: "flaky", prefix: "fake://", err: fmt.Errorf("upstream down")}
+	chain := services.NewResolverChain(
+		[]string{"flaky"},
+		map[string]services.VideoSource{"flaky": source},
+		1,
+		10*time.Millisecond,
+	)
+
+	if _, err := chain.Resolve("abc", 247); err == nil {
+		t.Fatal("Resolve() expected an error")
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The provider answers again, even if only to say the video is gone
+	source.err = fmt.Errorf("rapidapi: %w", services.ErrVideoUnavailable)
+	if _, err := chain.Resolve("abc", 247); services.ErrorCode(err) != services.ErrorCodeUnavailable {
+		t.Fatalf("Resolve() error = %v, want an unavailable video", err)
+	}
+	if states := chain.ProviderStates(); states["flaky"] != services.BreakerClosed {
+		t.Errorf("provider state = %s, want %s", states["flaky"], services.BreakerClosed)
+	}
+}
+
+func TestMediaURLClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("media"))
+	}))
+	defer server.Close()
+
+	client := services.NewMediaURLService(nil, nil, 0).Client()
+	resp, err := client.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("Get(%s) succeeded, want loopback refused", server.URL)
+	}
+}
+
+func TestUploadHandlerRejectsBadForms(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string // form field names in the order they are sent
+		format string
+	}{
+		{name: "format after file", fields: []string{"file", "format"}, format: "avi"},
+		{name: "unsupported format", fields: []string{"format", "file"}, format: "mkv"},
+		{name: "missing file", fields: []string{"format"}, format: "mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			conversions := services.NewConversionService(dir, dir, nil, nil, nil, nil)
+			handler := handlers.NewUploadHandler(conversions, 1<<20)
+
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			for _, field := range tt.fields {
+				if field == "file" {
+					part, _ := form.CreateFormFile("file", "clip.mp4")
+					part.Write([]byte("video data"))
+				} else {
+					form.WriteField(field, tt.format)
+				}
+			}
+			form.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("left %d files behind in the upload directory", len(entries))
+			}
+		})
+	}
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	const content = "0123456789abcdefghij"
+	serve := func(etag string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", etag)
+			http.ServeContent(w, r, "video.mp4", time.Time{}, strings.NewReader(content))
+		}
+	}
+	ignoreRanges := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}
+	// Resumes from byte 10 whatever it is asked, without an ETag to tell
+	// whether it is the same file
+	blindMirror := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "" {
+			w.Write([]byte(content))
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 10-19/%d", len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(content[10:]))
+	}
+	failing := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}
+
+	tests := []struct {
+		name     string
+		handlers []http.HandlerFunc
+		partial  string
+		etag     string
+	}{
+		{name: "resumes a partial file", handlers: []http.HandlerFunc{serve(`"v1"`)}, partial: "0123456789", etag: `"v1"`},
+		{name: "starts over when ranges are ignored", handlers: []http.HandlerFunc{ignoreRanges}, partial: "XXXXXXXXXX", etag: `"v1"`},
+		{name: "keeps a complete file", handlers: []http.HandlerFunc{serve(`"v1"`)}, partial: content, etag: `"v1"`},
+		{name: "starts over when the file changed", handlers: []http.HandlerFunc{serve(`"v2"`)}, partial: "XXXXXXXXXX", etag: `"v1"`},
+		{name: "starts over on an unconfirmed mirror", handlers: []http.HandlerFunc{failing, blindMirror}, partial: "XXXXXXXXXX", etag: `"v1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var urls []string
+			for _, h := range tt.handlers {
+				server := httptest.NewServer(h)
+				defer server.Close()
+				urls = append(urls, server.URL)
+			}
+			path := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(path, []byte(tt.partial), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			size, _, err := services.DownloadFile(context.Background(), urls, path, 0, int64(len(tt.partial)), tt.etag)
+			if err != nil {
+				t.Fatalf("DownloadFile() error = %v", err)
+			}
+			got, _ := os.ReadFile(path)
+			if string(got) != content || size != int64(len(content)) {
+				t.Errorf("file = %q (size %d), want %q", got, size, content)
+			}
+		})
+	}
+}
+
+// rangeServer serves content with range support and records the Range
+// header of every request. fail, when set, handles a request instead when
+// it returns true.
+type rangeServer struct {
+	content []byte
+	fail    func(w http.ResponseWriter, r *http.Request) bool
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.mu.Unlock()
+
+	if s.fail != nil && s.fail(w, r) {
+		return
+	}
+	w.Header().Set("ETag", `"v1"`)
+	http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(s.content))
+}
+
+func (s *rangeServer) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func TestDownloadFileSegments(t *testing.T) {
+	const mb = 1 << 20
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4*mb/16)
+
+	tests := []struct {
+		name        string
+		content     []byte
+		segments    int
+		ignoreRange bool
+		want        []string
+	}{
+		{
+			name:     "splits into equal ranges",
+			content:  content,
+			segments: 4,
+			want:     []string{"bytes=0-0", "bytes=0-1048575", "bytes=1048576-2097151", "bytes=2097152-3145727", "bytes=3145728-4194303"},
+		},
+		{
+			name:     "never splits below the minimum segment size",
+			content:  content,
+			segments: 16,
+			want:     []string{"bytes=0-0", "bytes=0-1048575", "bytes=1048576-2097151", "bytes=2097152-3145727", "bytes=3145728-4194303"},
+		},
+		{
+			name:     "small files use a single stream",
+			content:  content[:mb],
+			segments: 4,
+			want:     []string{"bytes=0-0", ""},
+		},
+		{
+			name:        "falls back without range support",
+			content:     content,
+			segments:    4,
+			ignoreRange: true,
+			want:        []string{"bytes=0-0", ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &rangeServer{content: tt.content}
+			if tt.ignoreRange {
+				server.fail = func(w http.ResponseWriter, r *http.Request) bool {
+					w.Write(tt.content)
+					return true
+				}
+			}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			path := filepath.Join(t.TempDir(), "video.mp4")
+			size, _, err := services.DownloadFile(context.Background(), []string{ts.URL}, path, tt.segments, 0, "")
+			if err != nil {
+				t.Fatalf("DownloadFile() error = %v", err)
+			}
+			if got, _ := os.ReadFile(path); !bytes.Equal(got, tt.content) || size != int64(len(tt.content)) {
+				t.Errorf("saved %d bytes (size %d), want the %d byte file", len(got), size, len(tt.content))
+			}
+
+			got := server.requested()
+			sort.Strings(got)
+			want := append([]string(nil), tt.want...)
+			sort.Strings(want)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("requested ranges %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDownloadFileSegmentRetry(t *testing.T) {
+	const mb = 1 << 20
+	content := bytes.Repeat([]byte("0123456789abcdef"), 2*mb/16)
+
+	// The second segment's first request is cut off halfway
+	var once sync.Once
+	server := &rangeServer{content: content}
+	server.fail = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Range") != "bytes=1048576-2097151" {
+			return false
+		}
+		failed := false
+		once.Do(func() {
+			failed = true
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", mb, 2*mb-1, len(content)))
+			w.Header().Set("Content-Length", fmt.Sprint(mb))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[mb : mb+mb/2])
+		})
+		return failed
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if _, _, err := services.DownloadFile(context.Background(), []string{ts.URL}, path, 2, 0, ""); err != nil {
+		t.Fatalf("DownloadFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, content) {
+		t.Error("saved file doesn't match the served one")
+	}
+
+	// Only the cut off segment is fetched again, from where it stopped
+	counts := make(map[string]int)
+	for _, r := range server.requested() {
+		counts[r]++
+	}
+	if counts["bytes=0-1048575"] != 1 {
+		t.Errorf("fetched the finished segment %d times, want 1", counts["bytes=0-1048575"])
+	}
+	if counts["bytes=1572864-2097151"] != 1 {
+		t.Errorf("resumed the cut off segment %d times, want 1; requests %q", counts["bytes=1572864-2097151"], server.requested())
+	}
+}
+
+func TestDownloadFileSegmentsKeepContiguousBytes(t *testing.T) {
+	const mb = 1 << 20
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4*mb/16)
+
+	// The third segment never arrives, so only the first two are usable
+	ctx, cancel := context.WithCancel(context.Background())
+	var served sync.WaitGroup
+	served.Add(3)
+	server := &rangeServer{content: content}
+	server.fail = func(w http.ResponseWriter, r *http.Request) bool {
+		switch r.Header.Get("Range") {
+		case "bytes=2097152-3145727":
+			<-r.Context().Done()
+			return true
+		case "bytes=0-1048575", "bytes=1048576-2097151", "bytes=3145728-4194303":
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(content))
+			served.Done()
+			return true
+		}
+		return false
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	go func() {
+		served.Wait()
+		// Give the client a moment to write what it was sent
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	offset, _, err := services.DownloadFile(ctx, []string{ts.URL}, path, 4, 0, "")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DownloadFile() error = %v, want context.Canceled", err)
+	}
+	if offset != 2*mb {
+		t.Errorf("offset = %d, want %d", offset, 2*mb)
+	}
+	// The file was allocated up front
+	if info, err := os.Stat(path); err != nil || info.Size() != int64(len(content)) {
+		t.Errorf("file is not preallocated to %d bytes: %v %v", len(content), info, err)
+	}
+}
+
+func TestJobsHandlerRoutes(t *testing.T) {
+	useOfflineDatabase(t)
+
+	queue := services.NewJobQueue(1, 1)
+	conversions := services.NewConversionService(t.TempDir(), t.TempDir(), nil, nil, nil, queue)
+	downloads := services.NewDirectDownloadService(t.TempDir(), t.TempDir(), nil, nil, queue)
+	handler := handlers.NewJobsHandler(conversions, downloads)
+
+	conversions.CreateJob("conversion", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "mp4", "", 0, 0)
+	downloads.CreateDownload("download", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "", 0, 0)
+	conversions.CreateJob("both", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "mp4", "", 0, 0)
+	downloads.CreateDownload("both", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "", 0, 0)
+
+	tests := []struct {
+		path string
+		want int
+		body string
+	}{
+		{path: "/api/jobs/conversion/pause", want: http.StatusOK, body: services.StatusPaused},
+		{path: "/api/jobs/download/pause", want: http.StatusOK, body: services.StatusPaused},
+		{path: "/api/jobs/download/cancel", want: http.StatusOK, body: services.StatusCancelled},
+		{path: "/api/jobs/both/cancel", want: http.StatusConflict, body: "Both a conversion and a download"},
+		{path: "/api/jobs/missing/cancel", want: http.StatusNotFound, body: services.ErrJobNotFound.Error()},
+		{path: "/api/jobs/conversion/restart", want: http.StatusNotFound, body: "Not found"},
+		{path: "/api/jobs/conversion/conversion/pause", want: http.StatusNotFound, body: "Not found"},
+		{path: "/api/jobs//pause", want: http.StatusNotFound, body: "Not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+// useOfflineDatabase points the database package at a Postgres server that
+// refuses connections, for services that save as they go. Saves fail and are
+// logged, and lookups find nothing.
+func useOfflineDatabase(t *testing.T) {
+	db, err := gorm.Open(postgres.Open("host=127.0.0.1 port=1 connect_timeout=1"), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = previous })
+}
+
+func TestPauseAndResumeJob(t *testing.T) {
+	useOfflineDatabase(t)
+
+	// Another job holds the only slot, so ours waits in the queue
+	runner := &blockingRunner{started: make(chan string, 1), release: make(chan struct{})}
+	defer close(runner.release)
+	queue := services.NewJobQueue(1, 1)
+	queue.Register(models.QueueKindConversion, runner)
+	queue.Submit(models.QueueKindConversion, "running", time.Now())
+	<-runner.started
+
+	conversions := services.NewConversionService(t.TempDir(), t.TempDir(), nil, nil, nil, queue)
+	job := conversions.CreateJob("job", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "mp4", "", 0, 0)
+	conversions.Enqueue(job, models.QualitySelection{})
+
+	steps := []struct {
+		name     string
+		action   func(id string) error
+		wantErr  error
+		status   string
+		position int
+	}{
+		{name: "pause a queued job", action: conversions.PauseJob, status: services.StatusPaused, position: 0},
+		{name: "pause it again", action: conversions.PauseJob, wantErr: services.ErrJobNotPausable, status: services.StatusPaused, position: 0},
+		{name: "resume it", action: conversions.ResumeJob, status: "queued", position: 1},
+		{name: "resume a queued job", action: conversions.ResumeJob, wantErr: services.ErrJobNotPaused, status: "queued", position: 1},
+		{name: "retry a queued job", action: conversions.RetryJob, wantErr: services.ErrJobNotRetryable, status: "queued", position: 1},
+		{name: "pause it once more", action: conversions.PauseJob, status: services.StatusPaused, position: 0},
+		{name: "retrying a paused job resumes it", action: conversions.RetryJob, status: "queued", position: 1},
+		{name: "cancel it", action: conversions.CancelJob, status: services.StatusCancelled, position: 0},
+		{name: "resume a cancelled job", action: conversions.ResumeJob, wantErr: services.ErrJobNotPaused, status: services.StatusCancelled, position: 0},
+	}
+
+	for _, step := range steps {
+		err := step.action("job")
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: error = %v, want %v", step.name, err, step.wantErr)
+		}
+		job.Mu.Lock()
+		status := job.Status
+		job.Mu.Unlock()
+		if status != step.status {
+			t.Errorf("%s: status = %s, want %s", step.name, status, step.status)
+		}
+		if pos := queue.Position(models.QueueKindConversion, "job"); pos != step.position {
+			t.Errorf("%s: queue position = %d, want %d", step.name, pos, step.position)
+		}
+	}
+}
+
+func TestRetryMediaURLJob(t *testing.T) {
+	useOfflineDatabase(t)
+
+	// Another job holds the only slot, so the retried one waits in the queue
+	runner := &blockingRunner{started: make(chan string, 1), release: make(chan struct{})}
+	defer close(runner.release)
+	queue := services.NewJobQueue(1, 1)
+	queue.Register(models.QueueKindConversion, runner)
+	queue.Submit(models.QueueKindConversion, "running", time.Now())
+	<-runner.started
+
+	conversions := services.NewConversionService(t.TempDir(), t.TempDir(), nil, nil, nil, queue)
+	if err := conversions.RetryJob("missing"); !errors.Is(err, services.ErrJobNotFound) {
+		t.Errorf("RetryJob() of a missing job error = %v, want %v", err, services.ErrJobNotFound)
+	}
+
+	probe := &services.MediaProbe{ID: "url_0123456789ab", URL: "https://cdn.example.com/video.mp4", Size: 100, Filename: "video.mp4"}
+	job := conversions.CreateJob("job", probe.URL, "avi", "", 0, 0)
+	conversions.EnqueueMediaURL(job, probe)
+	if err := conversions.CancelJob("job"); err != nil {
+		t.Fatalf("CancelJob() error = %v", err)
+	}
+
+	// Left behind by a download that failed half way
+	job.Mu.Lock()
+	job.Status = "failed"
+	job.ErrorCode = services.ErrorCodeUpstream
+	job.Progress = 0.5
+	job.BytesDone = 50
+	job.TransferRate = 10
+	job.ETASeconds = 5
+	job.Mu.Unlock()
+
+	if err := conversions.RetryJob("job"); err != nil {
+		t.Fatalf("RetryJob() error = %v", err)
+	}
+
+	job.Mu.Lock()
+	defer job.Mu.Unlock()
+	if job.Status != "queued" || job.ErrorCode != "" {
+		t.Errorf("status = %s with code %q, want queued without a code", job.Status, job.ErrorCode)
+	}
+	if job.Progress != 0 || job.BytesDone != 0 || job.TransferRate != 0 || job.ETASeconds != 0 {
+		t.Errorf("progress = %v, %d bytes at %v B/s, %ds left, want all 0", job.Progress, job.BytesDone, job.TransferRate, job.ETASeconds)
+	}
+}
+
+func TestPauseDuringMerge(t *testing.T) {
+	useOfflineDatabase(t)
+
+	queue := services.NewJobQueue(1, 1)
+	conversions := services.NewConversionService(t.TempDir(), t.TempDir(), nil, nil, nil, queue)
+	job := conversions.CreateJob("job", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "mp4", "", 0, 0)
+	job.Mu.Lock()
+	job.Status = services.StatusMuxing
+	job.Mu.Unlock()
+
+	if err := conversions.PauseJob("job"); !errors.Is(err, services.ErrJobNotPausable) {
+		t.Errorf("PauseJob() error = %v, want %v", err, services.ErrJobNotPausable)
+	}
+
+	// A merge stopped by its context, as a pause or cancel does, leaves the
+	// downloaded streams alone
+	dir := t.TempDir()
+	video, audio := filepath.Join(dir, "clip.video"), filepath.Join(dir, "clip.audio")
+	for _, path := range []string{video, audio} {
+		if err := os.WriteFile(path, []byte("stream"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := services.MuxStreams(ctx, video, audio, filepath.Join(dir, "clip.mkv")); !errors.Is(err, context.Canceled) {
+		t.Errorf("MuxStreams() error = %v, want context.Canceled", err)
+	}
+	for _, path := range []string{video, audio} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was removed: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestDownloadsHandler(t *testing.T) {
+	useOfflineDatabase(t)
+
+	downloads := services.NewDirectDownloadService(t.TempDir(), t.TempDir(), nil, nil, services.NewJobQueue(1, 1))
+	downloads.CreateDownload("download", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "", 0, 0)
+	handler := handlers.NewDownloadsHandler(downloads)
+
+	tests := []struct {
+		path string
+		want int
+		body string
+	}{
+		{path: "/api/downloads", want: http.StatusOK, body: `"id":"download"`},
+		{path: "/api/downloads/download", want: http.StatusOK, body: `"status":"resolving"`},
+		{path: "/api/downloads/download", want: http.StatusOK, body: `"bytesPerSecond":0`},
+		{path: "/api/downloads/missing", want: http.StatusNotFound, body: "Download not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS file_state TEXT;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS file_state TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS file_state;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS file_state;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS batches (
+	id TEXT PRIMARY KEY,
+	url TEXT NOT NULL,
+	playlist_id TEXT NOT NULL,
+	title TEXT,
+	format TEXT,
+	convert BOOLEAN NOT NULL DEFAULT FALSE,
+	status TEXT NOT NULL,
+	total INTEGER NOT NULL DEFAULT 0,
+	completed INTEGER NOT NULL DEFAULT 0,
+	failed INTEGER NOT NULL DEFAULT 0,
+	progress REAL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS batch_id TEXT REFERENCES batches(id) ON DELETE SET NULL;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS batch_id TEXT REFERENCES batches(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_conversion_jobs_batch_id ON conversion_jobs(batch_id);
+CREATE INDEX IF NOT EXISTS idx_direct_downloads_batch_id ON direct_downloads(batch_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS batch_id;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS batch_id;
+DROP TABLE IF EXISTS batches;
+-- +goose StatementEnd
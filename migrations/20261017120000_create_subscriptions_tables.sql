@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id SERIAL PRIMARY KEY,
+	channel_id TEXT,
+	feed_url TEXT NOT NULL,
+	title TEXT,
+	format TEXT,
+	convert BOOLEAN NOT NULL DEFAULT FALSE,
+	max_height INTEGER DEFAULT 0,
+	codec TEXT,
+	container TEXT,
+	itag INTEGER DEFAULT 0,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	last_checked_at TIMESTAMP,
+	last_error TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS subscription_seen_videos (
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	video_id TEXT NOT NULL,
+	seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (subscription_id, video_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS subscription_seen_videos;
+DROP TABLE IF EXISTS subscriptions;
+-- +goose StatementEnd
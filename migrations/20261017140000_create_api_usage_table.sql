@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS api_usage (
+	key_id TEXT NOT NULL,
+	day DATE NOT NULL,
+	requests INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (key_id, day)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS api_usage;
+-- +goose StatementEnd
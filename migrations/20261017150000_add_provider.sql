@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS provider TEXT;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS provider TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS provider;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS provider;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS mirror_url TEXT;
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS audio_mirror_url TEXT;
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS served_from TEXT;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS mirror_url TEXT;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS audio_mirror_url TEXT;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS served_from TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS mirror_url;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS audio_mirror_url;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS served_from;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS mirror_url;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS audio_mirror_url;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS served_from;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS error_code TEXT;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS error_code TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS error_code;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS error_code;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS resume_offset BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS resume_etag TEXT;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS resume_offset BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS resume_etag TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS resume_offset;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS resume_etag;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS resume_offset;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS resume_etag;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS segments INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS segments INTEGER NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS segments;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS segments;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS bytes_done BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS bytes_total BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS transfer_rate DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS eta_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS progress DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS bytes_done BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS bytes_total BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS transfer_rate DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS eta_seconds INTEGER NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS bytes_done;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS bytes_total;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS transfer_rate;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS eta_seconds;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS progress;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS bytes_done;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS bytes_total;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS transfer_rate;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS eta_seconds;
+-- +goose StatementEnd
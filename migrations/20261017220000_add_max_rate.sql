@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS max_rate BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS max_rate BIGINT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS max_rate;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS max_rate;
+-- +goose StatementEnd
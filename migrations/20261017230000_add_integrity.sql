@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS video_size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS audio_size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS sha256 TEXT NOT NULL DEFAULT '';
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS video_size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS audio_size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS sha256 TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS video_size;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS audio_size;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS sha256;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS video_size;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS audio_size;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS sha256;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS queued_at TIMESTAMP;
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS quality TEXT;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS queued_at TIMESTAMP;
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS quality TEXT;
+CREATE INDEX IF NOT EXISTS idx_conversion_jobs_queued ON conversion_jobs (queued_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_direct_downloads_queued ON direct_downloads (queued_at) WHERE status = 'queued';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_conversion_jobs_queued;
+DROP INDEX IF EXISTS idx_direct_downloads_queued;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS queued_at;
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS quality;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS queued_at;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS quality;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs ADD COLUMN IF NOT EXISTS audio_served_from TEXT NOT NULL DEFAULT '';
+ALTER TABLE direct_downloads ADD COLUMN IF NOT EXISTS audio_served_from TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE conversion_jobs DROP COLUMN IF EXISTS audio_served_from;
+ALTER TABLE direct_downloads DROP COLUMN IF EXISTS audio_served_from;
+-- +goose StatementEnd
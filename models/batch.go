@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Batch groups the jobs created from a single playlist submission.
+type Batch struct {
+	ID         string    `gorm:"primaryKey" json:"id"`
+	URL        string    `json:"url"`
+	PlaylistID string    `gorm:"column:playlist_id" json:"playlistId"`
+	Title      string    `json:"title"`
+	Format     string    `json:"format"`
+	Convert    bool      `json:"convert"`
+	Status     string    `json:"status"`
+	Total      int       `json:"total"`
+	Completed  int       `json:"completed"`
+	Failed     int       `json:"failed"`
+	Progress   float64   `json:"progress"`
+	CreatedAt  time.Time `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt  time.Time `gorm:"column:updated_at" json:"updatedAt"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ResolutionCacheEntry stores a provider response for a video and itag so
+// repeated submissions and retries don't spend API quota. Itag 0 holds the
+// video title only.
+type ResolutionCacheEntry struct {
+	VideoID   string    `gorm:"primaryKey;column:video_id"`
+	Itag      int       `gorm:"primaryKey;column:itag"`
+	Response  string    `gorm:"column:response"`
+	Title     string    `gorm:"column:title"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (ResolutionCacheEntry) TableName() string {
+	return "resolution_cache"
+}
@@ -6,18 +6,46 @@ import (
 )
 
 type ConversionJob struct {
-	ID          string `gorm:"primaryKey"`
-	URL         string
-	Format      string
-	Status      string
-	StartTime   time.Time
-	EndTime     *time.Time
-	Filename    *string
-	Error       *string
-	Progress    float64
-	DownloadURL string
-	VideoTitle  string     `gorm:"column:video_title"`
-	Mu          sync.Mutex `gorm:"-"`
+	ID             string `gorm:"primaryKey"`
+	URL            string
+	Format         string
+	Status         string
+	StartTime      time.Time
+	EndTime        *time.Time
+	Filename       *string
+	Error          *string
+	ErrorCode      string `gorm:"column:error_code"`
+	Progress       float64
+	DownloadURL    string
+	VideoTitle     string  `gorm:"column:video_title"`
+	FileState      string  `gorm:"column:file_state"`
+	Itag           int     `gorm:"column:itag"`
+	AudioURL       string  `gorm:"column:audio_url"`
+	BatchID        *string `gorm:"column:batch_id"`
+	Provider       string  `gorm:"column:provider"`
+	MirrorURL      string  `gorm:"column:mirror_url"`
+	AudioMirrorURL string  `gorm:"column:audio_mirror_url"`
+	ServedFrom     string  `gorm:"column:served_from"`
+	// AudioServedFrom is ServedFrom for the separate audio stream
+	AudioServedFrom string           `gorm:"column:audio_served_from"`
+	ResumeOffset    int64            `gorm:"column:resume_offset"`
+	ResumeETag      string           `gorm:"column:resume_etag"`
+	Segments        int              `gorm:"column:segments"`
+	MaxRate         int64            `gorm:"column:max_rate"`
+	VideoSize       int64            `gorm:"column:video_size"`
+	AudioSize       int64            `gorm:"column:audio_size"`
+	SHA256          string           `gorm:"column:sha256"`
+	QueuedAt        *time.Time       `gorm:"column:queued_at"`
+	Quality         QualitySelection `gorm:"column:quality;serializer:json"`
+	BytesDone       int64            `gorm:"column:bytes_done"`
+	BytesTotal      int64            `gorm:"column:bytes_total"`
+	TransferRate    float64          `gorm:"column:transfer_rate"`
+	ETASeconds      int              `gorm:"column:eta_seconds"`
+	Source          string           `gorm:"column:source"`
+	// InputPath is the downloaded or uploaded file waiting for ffmpeg. It is
+	// empty until the download is done and once the file is removed.
+	InputPath string     `gorm:"column:input_path"`
+	Mu        sync.Mutex `gorm:"-"`
 }
 
 type RapidAPIResponse struct {
@@ -32,19 +60,62 @@ type RapidAPIResponse struct {
 	Title        string `json:"title"`
 }
 
+func (r RapidAPIResponse) ToMediaStream() MediaStream {
+	return MediaStream{
+		Itag:         r.Quality,
+		Type:         r.Type,
+		Mime:         r.Mime,
+		Size:         r.Size,
+		Bitrate:      r.Bitrate,
+		File:         r.File,
+		ReservedFile: r.ReservedFile,
+		Title:        r.Title,
+	}
+}
+
 type DownloadRequest struct {
-	URL     string `json:"url"`
-	Format  string `json:"format"`
-	Convert bool   `json:"convert"`
+	URL     string           `json:"url"`
+	Format  string           `json:"format"`
+	Convert bool             `json:"convert"`
+	Quality QualitySelection `json:"quality"`
+	// Segments overrides how many parallel connections download the file
+	Segments int `json:"segments,omitempty"`
+	// MaxBytesPerSecond caps this job's download speed below the global limit
+	MaxBytesPerSecond int64 `json:"maxBytesPerSecond,omitempty"`
 }
 
 type DirectDownload struct {
-	ID           string    `gorm:"primaryKey"`
-	URL          string
-	Filename     string
-	DownloadTime time.Time `gorm:"column:download_time"`
-	Status       string
-	Error        *string
-	CreatedAt    time.Time `gorm:"column:created_at"`
-	UpdatedAt    time.Time `gorm:"column:updated_at"`
+	ID             string `gorm:"primaryKey"`
+	URL            string
+	Filename       string
+	DownloadTime   time.Time `gorm:"column:download_time"`
+	Status         string
+	Progress       float64
+	Error          *string
+	ErrorCode      string  `gorm:"column:error_code"`
+	FileState      string  `gorm:"column:file_state"`
+	Itag           int     `gorm:"column:itag"`
+	AudioURL       string  `gorm:"column:audio_url"`
+	BatchID        *string `gorm:"column:batch_id"`
+	Provider       string  `gorm:"column:provider"`
+	MirrorURL      string  `gorm:"column:mirror_url"`
+	AudioMirrorURL string  `gorm:"column:audio_mirror_url"`
+	ServedFrom     string  `gorm:"column:served_from"`
+	// AudioServedFrom is ServedFrom for the separate audio stream
+	AudioServedFrom string           `gorm:"column:audio_served_from"`
+	ResumeOffset    int64            `gorm:"column:resume_offset"`
+	ResumeETag      string           `gorm:"column:resume_etag"`
+	Segments        int              `gorm:"column:segments"`
+	MaxRate         int64            `gorm:"column:max_rate"`
+	VideoSize       int64            `gorm:"column:video_size"`
+	AudioSize       int64            `gorm:"column:audio_size"`
+	SHA256          string           `gorm:"column:sha256"`
+	QueuedAt        *time.Time       `gorm:"column:queued_at"`
+	Quality         QualitySelection `gorm:"column:quality;serializer:json"`
+	BytesDone       int64            `gorm:"column:bytes_done"`
+	BytesTotal      int64            `gorm:"column:bytes_total"`
+	TransferRate    float64          `gorm:"column:transfer_rate"`
+	ETASeconds      int              `gorm:"column:eta_seconds"`
+	CreatedAt       time.Time        `gorm:"column:created_at"`
+	UpdatedAt       time.Time        `gorm:"column:updated_at"`
 }
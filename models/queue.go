@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Kinds of queued job, named after the service that runs them.
+const (
+	QueueKindConversion = "conversion"
+	QueueKindDownload   = "download"
+)
+
+// QueuedJob is a conversion or direct download waiting for a worker.
+type QueuedJob struct {
+	ID       string
+	Kind     string
+	QueuedAt time.Time
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// APIUsage counts the requests made with one API key on one day.
+type APIUsage struct {
+	KeyID    string    `gorm:"primaryKey;column:key_id"`
+	Day      time.Time `gorm:"primaryKey;column:day;type:date"`
+	Requests int       `gorm:"column:requests"`
+}
+
+func (APIUsage) TableName() string {
+	return "api_usage"
+}
+
+type QuotaStatus struct {
+	KeyID         string        `json:"keyId,omitempty"`
+	Host          string        `json:"host,omitempty"`
+	CooldownUntil *time.Time    `json:"cooldownUntil,omitempty"`
+	Keys          []QuotaStatus `json:"keys,omitempty"`
+	Limit         int           `json:"limit"`
+	Remaining     int           `json:"remaining"`
+	ResetAt       *time.Time    `json:"resetAt"`
+	UsedToday     int           `json:"usedToday"`
+	DailyBudget   int           `json:"dailyBudget"`
+	Exhausted     bool          `json:"exhausted"`
+}
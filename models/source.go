@@ -0,0 +1,34 @@
+package models
+
+// MediaStream is a single downloadable stream returned by a video source.
+type MediaStream struct {
+	Itag         int    `json:"itag"`
+	Height       int    `json:"height,omitempty"`
+	Type         string `json:"type"`
+	Mime         string `json:"mime"`
+	Size         int64  `json:"size"`
+	Bitrate      int64  `json:"bitrate"`
+	File         string `json:"file"`
+	ReservedFile string `json:"reservedFile,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Provider     string `json:"provider,omitempty"`
+}
+
+// QualitySelection describes which stream the client wants. An explicit
+// Itag wins over the other fields.
+type QualitySelection struct {
+	MaxHeight int    `json:"maxHeight,omitempty"`
+	Codec     string `json:"codec,omitempty"`
+	Container string `json:"container,omitempty"`
+	Itag      int    `json:"itag,omitempty"`
+}
+
+func (q QualitySelection) IsZero() bool {
+	return q == QualitySelection{}
+}
+
+type VideoMetadata struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author,omitempty"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Subscription watches a channel (or any YouTube Atom feed) and creates a
+// job for every new upload using its own format and quality defaults.
+type Subscription struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	ChannelID     string     `gorm:"column:channel_id" json:"channelId"`
+	FeedURL       string     `gorm:"column:feed_url" json:"feedUrl"`
+	Title         string     `json:"title"`
+	Format        string     `json:"format"`
+	Convert       bool       `json:"convert"`
+	MaxHeight     int        `gorm:"column:max_height" json:"maxHeight"`
+	Codec         string     `json:"codec"`
+	Container     string     `json:"container"`
+	Itag          int        `json:"itag"`
+	Enabled       bool       `json:"enabled"`
+	LastCheckedAt *time.Time `gorm:"column:last_checked_at" json:"lastCheckedAt"`
+	LastError     *string    `gorm:"column:last_error" json:"lastError"`
+	CreatedAt     time.Time  `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at" json:"updatedAt"`
+}
+
+func (s *Subscription) Quality() QualitySelection {
+	return QualitySelection{
+		MaxHeight: s.MaxHeight,
+		Codec:     s.Codec,
+		Container: s.Container,
+		Itag:      s.Itag,
+	}
+}
+
+// SeenVideo records that a subscription has already handled a video, so
+// restarts don't enqueue it twice.
+type SeenVideo struct {
+	SubscriptionID uint      `gorm:"primaryKey;column:subscription_id"`
+	VideoID        string    `gorm:"primaryKey;column:video_id"`
+	SeenAt         time.Time `gorm:"column:seen_at"`
+}
+
+func (SeenVideo) TableName() string {
+	return "subscription_seen_videos"
+}
+
+type SubscriptionRequest struct {
+	ChannelID string           `json:"channelId"`
+	FeedURL   string           `json:"feedUrl"`
+	Format    string           `json:"format"`
+	Convert   bool             `json:"convert"`
+	Quality   QualitySelection `json:"quality"`
+	Enabled   *bool            `json:"enabled"`
+}
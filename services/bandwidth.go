@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BandwidthWindow limits downloads to BytesPerSecond between Start and End,
+// given as "15:04" in local time. A window whose end is before its start runs
+// past midnight. A limit of 0 means unlimited.
+type BandwidthWindow struct {
+	Start          string `json:"start"`
+	End            string `json:"end"`
+	BytesPerSecond int64  `json:"bytesPerSecond"`
+}
+
+// BandwidthLimits is the limiter's configuration. BytesPerSecond applies
+// whenever no schedule window does.
+type BandwidthLimits struct {
+	BytesPerSecond int64             `json:"bytesPerSecond"`
+	Schedule       []BandwidthWindow `json:"schedule"`
+}
+
+// ParseBandwidthSchedule reads a comma separated list of windows such as
+// "09:00-18:00=2048", with limits in KB per second.
+func ParseBandwidthSchedule(spec string) ([]BandwidthWindow, error) {
+	var schedule []BandwidthWindow
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		span, limit, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("window %q has no limit", item)
+		}
+		start, end, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("window %q has no end time", item)
+		}
+		kb, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64)
+		if err != nil || kb < 0 {
+			return nil, fmt.Errorf("window %q has an invalid limit", item)
+		}
+
+		schedule = append(schedule, BandwidthWindow{
+			Start:          strings.TrimSpace(start),
+			End:            strings.TrimSpace(end),
+			BytesPerSecond: kb << 10,
+		})
+	}
+
+	return normalizeSchedule(schedule)
+}
+
+// normalizeSchedule checks every window and rewrites its times as "15:04"
+// so they compare correctly.
+func normalizeSchedule(schedule []BandwidthWindow) ([]BandwidthWindow, error) {
+	normalized := make([]BandwidthWindow, 0, len(schedule))
+	for _, window := range schedule {
+		start, err := time.Parse("15:04", window.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start time %q", window.Start)
+		}
+		end, err := time.Parse("15:04", window.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end time %q", window.End)
+		}
+		if window.BytesPerSecond < 0 {
+			return nil, fmt.Errorf("limit for %s-%s can't be negative", window.Start, window.End)
+		}
+
+		window.Start = start.Format("15:04")
+		window.End = end.Format("15:04")
+		normalized = append(normalized, window)
+	}
+	return normalized, nil
+}
+
+// contains reports whether the window covers the time of day of t.
+func (w BandwidthWindow) contains(t time.Time) bool {
+	now := t.Format("15:04")
+	if w.Start <= w.End {
+		return now >= w.Start && now < w.End
+	}
+	return now >= w.Start || now < w.End
+}
+
+// BandwidthLimiter is a token bucket shared by every download, so running
+// jobs together stay under one cap. The limits can be changed while
+// downloads are running.
+type BandwidthLimiter struct {
+	mu     sync.Mutex
+	limits BandwidthLimits
+	bucket tokenBucket
+}
+
+func NewBandwidthLimiter(bytesPerSecond int64, schedule []BandwidthWindow) *BandwidthLimiter {
+	return &BandwidthLimiter{
+		limits: BandwidthLimits{BytesPerSecond: bytesPerSecond, Schedule: schedule},
+	}
+}
+
+func (l *BandwidthLimiter) Limits() BandwidthLimits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limits
+}
+
+// SetLimits replaces the limits; downloads pick them up on their next read.
+func (l *BandwidthLimiter) SetLimits(limits BandwidthLimits) error {
+	if limits.BytesPerSecond < 0 {
+		return fmt.Errorf("limit can't be negative")
+	}
+	schedule, err := normalizeSchedule(limits.Schedule)
+	if err != nil {
+		return err
+	}
+	limits.Schedule = schedule
+
+	l.mu.Lock()
+	l.limits = limits
+	l.mu.Unlock()
+	return nil
+}
+
+// ActiveLimit is the limit in force right now, taking the schedule into
+// account. 0 means unlimited.
+func (l *BandwidthLimiter) ActiveLimit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.activeLimit(time.Now())
+}
+
+// activeLimit must be called with l.mu held.
+func (l *BandwidthLimiter) activeLimit(now time.Time) int64 {
+	for _, window := range l.limits.Schedule {
+		if window.contains(now) {
+			return window.BytesPerSecond
+		}
+	}
+	return l.limits.BytesPerSecond
+}
+
+func (l *BandwidthLimiter) reserve(n int64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	return l.bucket.reserve(n, l.activeLimit(now), now)
+}
+
+// ForJob returns a throttle for one job's downloads, which also keeps the job
+// under its own cap when bytesPerSecond is above 0.
+func (l *BandwidthLimiter) ForJob(bytesPerSecond int64) *throttle {
+	return &throttle{global: l, rate: bytesPerSecond}
+}
+
+// tokenBucket allows a second's worth of burst. Reads take tokens up front
+// and wait off any debt, so concurrent readers share the rate.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes n tokens and returns how long to wait before using them.
+func (b *tokenBucket) reserve(n, rate int64, now time.Time) time.Duration {
+	if rate <= 0 {
+		b.tokens = 0
+		b.last = now
+		return 0
+	}
+
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+	}
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
+// throttle slows one job's reads to the global limit and the job's own cap.
+// A nil *throttle doesn't limit anything.
+type throttle struct {
+	global *BandwidthLimiter
+	rate   int64
+
+	mu     sync.Mutex
+	bucket tokenBucket
+}
+
+// wait blocks until n bytes may be used. It gives up early with the
+// context's error when the job is cancelled or paused meanwhile.
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t == nil || n <= 0 {
+		return nil
+	}
+
+	var delay time.Duration
+	if t.global != nil {
+		delay = t.global.reserve(int64(n))
+	}
+	if t.rate > 0 {
+		t.mu.Lock()
+		if d := t.bucket.reserve(int64(n), t.rate, time.Now()); d > delay {
+			delay = d
+		}
+		t.mu.Unlock()
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// throttledReader waits for bandwidth after every read.
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	throttle *throttle
+}
+
+func (t *throttledReader) Read(buf []byte) (int, error) {
+	n, err := t.r.Read(buf)
+	if waitErr := t.throttle.wait(t.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vicradon/yt-downloader/database"
+	"github.com/vicradon/yt-downloader/models"
+)
+
+type BatchService struct {
+	sources               *SourceRegistry
+	conversionService     *ConversionService
+	directDownloadService *DirectDownloadService
+}
+
+func NewBatchService(sources *SourceRegistry, conversionService *ConversionService, directDownloadService *DirectDownloadService) *BatchService {
+	return &BatchService{
+		sources:               sources,
+		conversionService:     conversionService,
+		directDownloadService: directDownloadService,
+	}
+}
+
+// CreateBatch expands a playlist and creates one job per video, all grouped
+// under a new batch. It returns the batch and the IDs of the created jobs.
+func (s *BatchService) CreateBatch(playlistSource PlaylistSource, playlistID string, req models.DownloadRequest) (*models.Batch, []string, error) {
+	title, videos, err := playlistSource.PlaylistVideos(playlistID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	batch := &models.Batch{
+		ID:         fmt.Sprintf("%s_%d", playlistID, now.Unix()),
+		URL:        req.URL,
+		PlaylistID: playlistID,
+		Title:      title,
+		Format:     req.Format,
+		Convert:    req.Convert,
+		Status:     "processing",
+		Total:      len(videos),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := database.SaveBatch(batch); err != nil {
+		return nil, nil, fmt.Errorf("failed to save batch: %w", err)
+	}
+
+	jobIDs := make([]string, 0, len(videos))
+	for i, video := range videos {
+		videoURL := "https://www.youtube.com/watch?v=" + video.ID
+		_, videoID, err := s.sources.Lookup(videoURL)
+		if err != nil {
+			log.Printf("Batch %s: skipping %s: %v", batch.ID, video.ID, err)
+			continue
+		}
+
+		// A playlist can hold the same video more than once
+		jobID := fmt.Sprintf("%s_%d_%d", videoID, now.Unix(), i+1)
+		if req.Convert {
+			job := s.conversionService.CreateJob(jobID, videoURL, req.Format, batch.ID, req.Segments, req.MaxBytesPerSecond)
+			s.conversionService.Enqueue(job, req.Quality)
+		} else {
+			download := s.directDownloadService.CreateDownload(jobID, videoURL, batch.ID, req.Segments, req.MaxBytesPerSecond)
+			s.directDownloadService.Enqueue(download, req.Quality)
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+
+	if len(jobIDs) != batch.Total {
+		batch.Total = len(jobIDs)
+		database.SaveBatch(batch)
+	}
+
+	log.Printf("Batch %s: created %d jobs from playlist %s", batch.ID, len(jobIDs), playlistID)
+	return batch, jobIDs, nil
+}
+
+func (s *BatchService) GetAllBatches() ([]models.Batch, error) {
+	return database.LoadBatches()
+}
+
+// GetBatch returns a batch together with its jobs.
+func (s *BatchService) GetBatch(id string) (map[string]interface{}, error) {
+	batch, err := database.GetBatch(id)
+	if err != nil {
+		return nil, fmt.Errorf("batch not found")
+	}
+
+	var jobs []map[string]interface{}
+	if batch.Convert {
+		conversions, err := database.LoadBatchConversions(id)
+		if err != nil {
+			return nil, err
+		}
+		jobsList := make([]*models.ConversionJob, len(conversions))
+		for i := range conversions {
+			jobsList[i] = &conversions[i]
+		}
+		jobs = s.conversionService.buildJobResponse(jobsList)
+	} else {
+		downloads, err := database.LoadBatchDirectDownloads(id)
+		if err != nil {
+			return nil, err
+		}
+		jobs = s.directDownloadService.buildDownloadResponse(downloads)
+	}
+
+	return map[string]interface{}{
+		"batch": batch,
+		"jobs":  jobs,
+	}, nil
+}
+
+func updateBatchProgress(batchID *string) {
+	if batchID == nil {
+		return
+	}
+	if err := database.UpdateBatchProgress(*batchID); err != nil {
+		log.Printf("Failed to update batch %s progress: %v", *batchID, err)
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// CircuitBreaker stops sending requests to a provider after repeated
+// failures. Once the cooldown has passed a single trial request is let
+// through; its outcome closes or re-opens the breaker.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openUntil time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreaker{
+		state:     BreakerClosed,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow reports whether a request may be sent now.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// A trial request is already in flight
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.mu.Unlock()
+}
+
+func (b *CircuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && !time.Now().Before(b.openUntil) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
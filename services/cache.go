@@ -0,0 +1,118 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/vicradon/yt-downloader/database"
+	"github.com/vicradon/yt-downloader/models"
+)
+
+// cacheMargin is how much life a cached URL must have left to be reused, so
+// the download doesn't start on a URL that is about to expire.
+const cacheMargin = 10 * time.Minute
+
+// SignedURLExpiry reads the `expire` parameter (unix seconds) that signed
+// CDN URLs such as googlevideo carry.
+func SignedURLExpiry(fileURL string) (time.Time, bool) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expire, err := strconv.ParseInt(u.Query().Get("expire"), 10, 64)
+	if err != nil || expire <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(expire, 0), true
+}
+
+func (s *YouTubeService) cachedDownloadURL(videoID string, itag int) (*models.RapidAPIResponse, bool) {
+	entry, err := database.GetCachedResolution(videoID, itag, time.Now().Add(cacheMargin))
+	if err != nil || entry.Response == "" {
+		return nil, false
+	}
+
+	var rapidResp models.RapidAPIResponse
+	if err := json.Unmarshal([]byte(entry.Response), &rapidResp); err != nil {
+		return nil, false
+	}
+	return &rapidResp, true
+}
+
+func (s *YouTubeService) cacheDownloadURL(videoID string, itag int, rapidResp *models.RapidAPIResponse) {
+	expiresAt, ok := SignedURLExpiry(rapidResp.File)
+	if !ok {
+		expiresAt = time.Now().Add(s.CacheTTL)
+	}
+
+	body, err := json.Marshal(rapidResp)
+	if err != nil {
+		return
+	}
+
+	entry := &models.ResolutionCacheEntry{
+		VideoID:   videoID,
+		Itag:      itag,
+		Response:  string(body),
+		Title:     rapidResp.Title,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := database.SaveCachedResolution(entry); err != nil {
+		log.Printf("Failed to cache resolution for %s/%d: %v", videoID, itag, err)
+	}
+}
+
+// forgetResolution drops the cached download URL a job was resolved to once
+// it fails to download, so the next attempt asks the provider for a fresh
+// one. Jobs that aren't YouTube videos have nothing cached.
+func forgetResolution(videoURL string, itag int) {
+	parsed, err := ParseYouTubeURL(videoURL)
+	if err != nil || parsed.VideoID == "" || itag == 0 {
+		return
+	}
+	if err := database.DeleteCachedResolution(parsed.VideoID, itag); err != nil {
+		log.Printf("Failed to drop cached resolution for %s/%d: %v", parsed.VideoID, itag, err)
+	}
+}
+
+// PruneResolutionCache removes expired cache entries now and then on every
+// interval until the process exits.
+func PruneResolutionCache(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := database.DeleteExpiredResolutions(); err != nil {
+				log.Printf("Failed to prune resolution cache: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *YouTubeService) cachedTitle(videoID string) (string, bool) {
+	entry, err := database.GetCachedResolution(videoID, 0, time.Now())
+	if err != nil || entry.Title == "" {
+		return "", false
+	}
+	return entry.Title, true
+}
+
+// Titles don't expire with the signed URL, so they are kept for a day.
+func (s *YouTubeService) cacheTitle(videoID, title string) {
+	entry := &models.ResolutionCacheEntry{
+		VideoID:   videoID,
+		Itag:      0,
+		Title:     title,
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+		CreatedAt: time.Now(),
+	}
+	if err := database.SaveCachedResolution(entry); err != nil {
+		log.Printf("Failed to cache title for %s: %v", videoID, err)
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// StatusCancelled is the final status of a job stopped by CancelJob or
+// CancelDownload.
+const StatusCancelled = "cancelled"
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job has already finished")
+)
+
+// finished reports whether a job with this status can no longer be
+// cancelled.
+func finished(status string) bool {
+	return status == "completed" || status == "failed" || status == StatusCancelled || status == StatusInterrupted
+}
+
+type jobContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// jobContexts hands out a cancellable context to each job that is running.
+type jobContexts struct {
+	mu   sync.Mutex
+	jobs map[string]jobContext
+}
+
+func newJobContexts() *jobContexts {
+	return &jobContexts{jobs: make(map[string]jobContext)}
+}
+
+// get returns the context of a running job, creating it on first use.
+func (c *jobContexts) get(id string) context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	job, ok := c.jobs[id]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		job = jobContext{ctx, cancel}
+		c.jobs[id] = job
+	}
+	return job.ctx
+}
+
+// cancel cancels a running job's context. It reports false when the job
+// isn't running.
+func (c *jobContexts) cancel(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	job, ok := c.jobs[id]
+	if ok {
+		job.cancel()
+	}
+	return ok
+}
+
+// running reports whether a job still holds its context.
+func (c *jobContexts) running(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.jobs[id]
+	return ok
+}
+
+// done releases a job's context once the job has stopped.
+func (c *jobContexts) done(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if job, ok := c.jobs[id]; ok {
+		job.cancel()
+		delete(c.jobs, id)
+	}
+}
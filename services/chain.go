@@ -0,0 +1,219 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vicradon/yt-downloader/models"
+)
+
+type chainProvider struct {
+	source  VideoSource
+	breaker *CircuitBreaker
+}
+
+// ResolverChain tries an ordered list of providers for the same kind of URL,
+// moving on to the next one when a provider fails. Each provider has its
+// own circuit breaker so a provider that keeps failing is skipped.
+type ResolverChain struct {
+	providers []*chainProvider
+
+	mu sync.Mutex
+	// demoted maps a video ID to the provider to try last for it
+	demoted map[string]string
+}
+
+// NewResolverChain orders the available providers by names. Unknown names
+// are logged and skipped.
+func NewResolverChain(names []string, available map[string]VideoSource, threshold int, cooldown time.Duration) *ResolverChain {
+	chain := &ResolverChain{demoted: make(map[string]string)}
+	for _, name := range names {
+		source, ok := available[name]
+		if !ok {
+			log.Printf("Resolver %q is not configured, skipping it", name)
+			continue
+		}
+		chain.providers = append(chain.providers, &chainProvider{
+			source:  source,
+			breaker: NewCircuitBreaker(threshold, cooldown),
+		})
+	}
+	return chain
+}
+
+func (c *ResolverChain) Name() string {
+	return "chain"
+}
+
+func (c *ResolverChain) Providers() []VideoSource {
+	sources := make([]VideoSource, len(c.providers))
+	for i, p := range c.providers {
+		sources[i] = p.source
+	}
+	return sources
+}
+
+// Demote makes resolutions of videoID try provider last, e.g. when a retry
+// follows a download of a URL it handed out that failed. The hint is kept
+// until the video is demoted again.
+func (c *ResolverChain) Demote(videoID, provider string) {
+	c.mu.Lock()
+	c.demoted[videoID] = provider
+	c.mu.Unlock()
+}
+
+// order returns the providers in the order they are tried for videoID.
+func (c *ResolverChain) order(videoID string) []*chainProvider {
+	c.mu.Lock()
+	demoted := c.demoted[videoID]
+	c.mu.Unlock()
+
+	if demoted == "" {
+		return c.providers
+	}
+
+	ordered := make([]*chainProvider, 0, len(c.providers))
+	var last []*chainProvider
+	for _, p := range c.providers {
+		if p.source.Name() == demoted {
+			last = append(last, p)
+		} else {
+			ordered = append(ordered, p)
+		}
+	}
+	return append(ordered, last...)
+}
+
+// try runs fn against each provider for videoID in order, skipping those
+// whose breaker is open, until one succeeds.
+func (c *ResolverChain) try(videoID string, fn func(source VideoSource) error) error {
+	var errs []error
+	for _, p := range c.order(videoID) {
+		if !p.breaker.Allow() {
+			continue
+		}
+
+		err := fn(p.source)
+		if err == nil {
+			p.breaker.RecordSuccess()
+			return nil
+		}
+		// A missing or restricted video is the same on every provider and
+		// says nothing about the provider's health, other than that it
+		// answered. A half-open breaker closes again.
+		if !Retryable(ErrorCode(err)) {
+			p.breaker.RecordSuccess()
+			return fmt.Errorf("%s: %w", p.source.Name(), err)
+		}
+		p.breaker.RecordFailure()
+		log.Printf("Provider %s failed, trying next: %v", p.source.Name(), err)
+		errs = append(errs, fmt.Errorf("%s: %w", p.source.Name(), err))
+	}
+
+	if len(errs) == 0 {
+		return fmt.Errorf("all resolution providers are unavailable")
+	}
+	return errors.Join(errs...)
+}
+
+func (c *ResolverChain) ExtractVideoID(url string) (string, error) {
+	if len(c.providers) == 0 {
+		return "", fmt.Errorf("no resolution providers configured")
+	}
+	return c.providers[0].source.ExtractVideoID(url)
+}
+
+func (c *ResolverChain) Resolve(videoID string, itag int) (*models.MediaStream, error) {
+	var stream *models.MediaStream
+	err := c.try(videoID, func(source VideoSource) error {
+		var err error
+		stream, err = source.Resolve(videoID, itag)
+		if err == nil && stream.Provider == "" {
+			stream.Provider = source.Name()
+		}
+		return err
+	})
+	return stream, err
+}
+
+func (c *ResolverChain) Metadata(videoID string) (*models.VideoMetadata, error) {
+	var metadata *models.VideoMetadata
+	err := c.try(videoID, func(source VideoSource) error {
+		var err error
+		metadata, err = source.Metadata(videoID)
+		return err
+	})
+	return metadata, err
+}
+
+func (c *ResolverChain) Formats(videoID string) ([]models.MediaStream, error) {
+	var formats []models.MediaStream
+	err := c.try(videoID, func(source VideoSource) error {
+		var err error
+		formats, err = source.Formats(videoID)
+		return err
+	})
+	return formats, err
+}
+
+// ReportFailure counts a failure against a provider outside of resolution,
+// e.g. when the URL it handed out could not be downloaded.
+func (c *ResolverChain) ReportFailure(name string) {
+	for _, p := range c.providers {
+		if p.source.Name() == name {
+			p.breaker.RecordFailure()
+		}
+	}
+}
+
+// ProviderStates returns the breaker state of every provider by name.
+func (c *ResolverChain) ProviderStates() map[string]string {
+	states := make(map[string]string)
+	for _, p := range c.providers {
+		states[p.source.Name()] = p.breaker.State()
+	}
+	return states
+}
+
+// QuotaExhausted is true only when every provider is metered and out of
+// quota; otherwise another provider can still serve the request.
+func (c *ResolverChain) QuotaExhausted() bool {
+	for _, p := range c.providers {
+		guard, ok := p.source.(QuotaGuard)
+		if !ok || !guard.QuotaExhausted() {
+			return false
+		}
+	}
+	return len(c.providers) > 0
+}
+
+func (c *ResolverChain) ExtractPlaylistID(url string) (string, bool) {
+	for _, p := range c.providers {
+		if playlistSource, ok := p.source.(PlaylistSource); ok {
+			return playlistSource.ExtractPlaylistID(url)
+		}
+	}
+	return "", false
+}
+
+// PlaylistVideos asks each provider that can expand playlists in turn,
+// moving on to the next one when a provider fails.
+func (c *ResolverChain) PlaylistVideos(playlistID string) (string, []models.VideoMetadata, error) {
+	lastErr := fmt.Errorf("no provider can expand playlists")
+	for _, p := range c.providers {
+		playlistSource, ok := p.source.(PlaylistSource)
+		if !ok {
+			continue
+		}
+		title, videos, err := playlistSource.PlaylistVideos(playlistID)
+		if err == nil {
+			return title, videos, nil
+		}
+		log.Printf("Provider %s failed to expand playlist %s: %v", p.source.Name(), playlistID, err)
+		lastErr = err
+	}
+	return "", nil, lastErr
+}
@@ -1,10 +1,11 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
@@ -18,19 +19,39 @@ import (
 )
 
 type ConversionService struct {
+	// DownloadSegments is how many parallel connections fetch a video when
+	// the job doesn't say.
+	DownloadSegments int
+	// Bandwidth, when set, limits how fast videos are downloaded
+	Bandwidth *BandwidthLimiter
+	// MediaURLs, when set, restricts how direct media links are fetched
+	MediaURLs *MediaURLService
+
 	conversions    map[string]*models.ConversionJob
 	mu             sync.RWMutex
 	ongoingDir     string
 	completedDir   string
 	storageService *StorageService
+	prober         *ReadinessProber
+	sources        *SourceRegistry
+	queue          *JobQueue
+	contexts       *jobContexts
 }
 
-func NewConversionService(ongoingDir, completedDir string, storageService *StorageService) *ConversionService {
+// JobSourceUpload marks conversions of uploaded files. Jobs created from a
+// URL leave Source empty.
+const JobSourceUpload = "upload"
+
+func NewConversionService(ongoingDir, completedDir string, storageService *StorageService, prober *ReadinessProber, sources *SourceRegistry, queue *JobQueue) *ConversionService {
 	return &ConversionService{
 		conversions:    make(map[string]*models.ConversionJob),
 		ongoingDir:     ongoingDir,
 		completedDir:   completedDir,
 		storageService: storageService,
+		prober:         prober,
+		sources:        sources,
+		queue:          queue,
+		contexts:       newJobContexts(),
 	}
 }
 
@@ -40,10 +61,10 @@ func (s *ConversionService) LoadFromDatabase() error {
 		return err
 	}
 
-	for _, job := range jobs {
-		jobCopy := job
+	for i := range jobs {
+		job := &jobs[i]
 		s.mu.Lock()
-		s.conversions[job.ID] = &jobCopy
+		s.conversions[job.ID] = job
 		s.mu.Unlock()
 
 		if job.Status == "failed" && job.DownloadURL != "" {
@@ -54,26 +75,47 @@ func (s *ConversionService) LoadFromDatabase() error {
 	return nil
 }
 
-func (s *ConversionService) CreateJob(jobID, url, format, downloadURL, videoTitle string) *models.ConversionJob {
+// CreateJob records a new job in the "resolving" state until it is queued.
+// The download URL and title are filled in later by ResolveAndProcess.
+// batchID is empty for jobs that aren't part of a playlist. maxRate caps the
+// job's download speed in bytes per second, 0 for no cap of its own.
+func (s *ConversionService) CreateJob(jobID, url, format, batchID string, segments int, maxRate int64) *models.ConversionJob {
+	job, err := s.createJob(jobID, url, format, batchID, segments, maxRate)
+	if err != nil {
+		log.Printf("Failed to save job to database: %v", err)
+		// The job can still run, it just won't survive a restart
+		s.mu.Lock()
+		s.conversions[jobID] = job
+		s.mu.Unlock()
+	}
+	return job
+}
+
+// createJob is CreateJob for callers that need the job to be saved. A job
+// that can't be saved isn't tracked.
+func (s *ConversionService) createJob(jobID, url, format, batchID string, segments int, maxRate int64) (*models.ConversionJob, error) {
 	job := &models.ConversionJob{
-		ID:          jobID,
-		URL:         url,
-		Format:      format,
-		Status:      "downloading",
-		StartTime:   time.Now(),
-		DownloadURL: downloadURL,
-		VideoTitle:  videoTitle,
+		ID:        jobID,
+		URL:       url,
+		Format:    format,
+		Status:    "resolving",
+		StartTime: time.Now(),
+		Segments:  segments,
+		MaxRate:   maxRate,
+	}
+	if batchID != "" {
+		job.BatchID = &batchID
+	}
+
+	if err := database.SaveConversion(job); err != nil {
+		return job, err
 	}
 
 	s.mu.Lock()
 	s.conversions[jobID] = job
 	s.mu.Unlock()
 
-	if err := database.SaveConversion(job); err != nil {
-		log.Printf("Failed to save job to database: %v", err)
-	}
-
-	return job
+	return job, nil
 }
 
 func (s *ConversionService) GetJob(jobID string) (*models.ConversionJob, bool) {
@@ -92,20 +134,35 @@ func (s *ConversionService) GetAllJobs() []map[string]interface{} {
 		return s.getJobsFromMemory()
 	}
 
-	// Update in-memory map with latest DB data
+	// Update in-memory map with latest DB data. Jobs this process is still
+	// working on keep their entry, since goroutines hold pointers to it.
 	s.mu.Lock()
-	for _, job := range jobs {
-		jobCopy := job
-		if existing, exists := s.conversions[job.ID]; exists {
-			// Preserve the mutex from existing job
-			jobCopy.Mu = existing.Mu
+	for i := range jobs {
+		if existing, exists := s.conversions[jobs[i].ID]; exists && s.inUse(existing) {
+			continue
 		}
-		s.conversions[job.ID] = &jobCopy
+		s.conversions[jobs[i].ID] = &jobs[i]
 	}
 	s.mu.Unlock()
 
 	// Now return the jobs
-	return s.buildJobResponse(jobs)
+	jobsList := make([]*models.ConversionJob, len(jobs))
+	for i := range jobs {
+		jobsList[i] = &jobs[i]
+	}
+	return s.buildJobResponse(jobsList)
+}
+
+// inUse reports whether a job is being created or run by this process. The
+// caller must hold s.mu.
+func (s *ConversionService) inUse(job *models.ConversionJob) bool {
+	if s.contexts.running(job.ID) {
+		return true
+	}
+	job.Mu.Lock()
+	defer job.Mu.Unlock()
+	// Handlers hold on to new jobs until they are queued
+	return job.Status == "resolving"
 }
 
 func (s *ConversionService) getJobsFromMemory() []map[string]interface{} {
@@ -121,15 +178,10 @@ func (s *ConversionService) getJobsFromMemory() []map[string]interface{} {
 		return jobsList[i].StartTime.After(jobsList[j].StartTime)
 	})
 
-	jobs := make([]models.ConversionJob, len(jobsList))
-	for i, job := range jobsList {
-		jobs[i] = *job
-	}
-
-	return s.buildJobResponse(jobs)
+	return s.buildJobResponse(jobsList)
 }
 
-func (s *ConversionService) buildJobResponse(jobs []models.ConversionJob) []map[string]interface{} {
+func (s *ConversionService) buildJobResponse(jobs []*models.ConversionJob) []map[string]interface{} {
 	// Sort by start time (newest first)
 	sort.Slice(jobs, func(i, j int) bool {
 		return jobs[i].StartTime.After(jobs[j].StartTime)
@@ -149,19 +201,38 @@ func (s *ConversionService) buildJobResponse(jobs []models.ConversionJob) []map[
 		if job.EndTime != nil {
 			endTime = *job.EndTime
 		}
+		var batchID string
+		if job.BatchID != nil {
+			batchID = *job.BatchID
+		}
 		jobMap := map[string]interface{}{
-			"id":         job.ID,
-			"url":        job.URL,
-			"format":     job.Format,
-			"status":     job.Status,
-			"startTime":  job.StartTime,
-			"endTime":    endTime,
-			"filename":   filename,
-			"error":      errorMsg,
-			"progress":   job.Progress,
-			"size":       s.storageService.GetFormattedFileSize(filename),
-			"canRetry":   job.Status == "failed" && job.DownloadURL != "",
-			"videoTitle": job.VideoTitle, // Add this
+			"id":              job.ID,
+			"url":             job.URL,
+			"format":          job.Format,
+			"status":          job.Status,
+			"startTime":       job.StartTime,
+			"endTime":         endTime,
+			"filename":        filename,
+			"error":           errorMsg,
+			"progress":        job.Progress,
+			"size":            s.storageService.GetFormattedFileSize(filename),
+			"errorCode":       job.ErrorCode,
+			"errorMessage":    ErrorMessage(job.ErrorCode),
+			"canRetry":        (job.Status == "failed" || job.Status == StatusInterrupted) && Retryable(job.ErrorCode) && job.Source != JobSourceUpload,
+			"itag":            job.Itag,
+			"videoTitle":      job.VideoTitle,
+			"fileState":       job.FileState,
+			"batchId":         batchID,
+			"provider":        job.Provider,
+			"servedFrom":      job.ServedFrom,
+			"audioServedFrom": job.AudioServedFrom,
+			"source":          job.Source,
+			"bytesDone":       job.BytesDone,
+			"bytesTotal":      job.BytesTotal,
+			"bytesPerSecond":  job.TransferRate,
+			"etaSeconds":      job.ETASeconds,
+			"sha256":          job.SHA256,
+			"queuePosition":   s.queue.Position(models.QueueKindConversion, job.ID),
 		}
 		result = append(result, jobMap)
 	}
@@ -169,135 +240,749 @@ func (s *ConversionService) buildJobResponse(jobs []models.ConversionJob) []map[
 	return result
 }
 
-func (s *ConversionService) ProcessConversion(job *models.ConversionJob, downloadURL, format, videoTitle string) {
+// ResolveAndProcess asks the source for a download URL and then runs the
+// conversion. Resolution failures are stored on the job.
+func (s *ConversionService) ResolveAndProcess(ctx context.Context, job *models.ConversionJob, source VideoSource, videoID string, quality models.QualitySelection) {
+	media, err := resolveMedia(source, videoID, quality)
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		s.markJobFailed(job, "Failed to get download URL", err)
+		log.Printf("Job %s failed to resolve: %v", job.ID, err)
+		return
+	}
+
 	job.Mu.Lock()
-	job.Status = "downloading"
-	job.Progress = 0.25
+	job.DownloadURL = media.Stream.File
+	job.MirrorURL = media.Stream.ReservedFile
+	job.VideoSize = media.Stream.Size
+	job.AudioSize = 0
+	job.BytesTotal = media.Stream.Size
+	job.AudioURL = ""
+	job.AudioMirrorURL = ""
+	if media.Audio != nil {
+		job.AudioURL = media.Audio.File
+		job.AudioMirrorURL = media.Audio.ReservedFile
+		job.AudioSize = media.Audio.Size
+		job.BytesTotal += media.Audio.Size
+	}
+	job.ServedFrom = ""
+	job.AudioServedFrom = ""
+	job.VideoTitle = media.Title
+	job.Itag = media.Stream.Itag
+	job.Provider = media.Stream.Provider
 	database.SaveConversion(job)
 	job.Mu.Unlock()
 
-	// Use video title for filename (sanitize it)
-	sanitizedTitle := sanitizeFilename(videoTitle)
+	s.ProcessConversion(ctx, job, media.Stream.File, job.Format, media.Title)
+}
+
+// SaveUpload streams an uploaded file into the ongoing directory, named
+// after the job so uploads with the same filename don't collide.
+func (s *ConversionService) SaveUpload(jobID, filename string, r io.Reader) (string, error) {
+	inputPath := s.uploadPath(jobID, filename)
+
+	out, err := os.Create(inputPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	defer out.Close()
+
+	size, err := io.Copy(out, r)
+	if err != nil {
+		os.Remove(inputPath)
+		return "", err
+	}
+
+	log.Printf("Job %s: Received upload %s (%d bytes)", jobID, filename, size)
+	return inputPath, nil
+}
+
+// uploadPath is where SaveUpload keeps a job's uploaded file.
+func (s *ConversionService) uploadPath(jobID, filename string) string {
+	return filepath.Join(s.ongoingDir, jobID+sanitizeFilename(filepath.Ext(filename)))
+}
+
+// CreateUploadJob records a conversion of a file that was uploaded rather
+// than downloaded. It is queued with EnqueueUpload.
+func (s *ConversionService) CreateUploadJob(jobID, filename, format string) *models.ConversionJob {
+	job := &models.ConversionJob{
+		ID:         jobID,
+		URL:        filename,
+		Format:     format,
+		Status:     "resolving",
+		StartTime:  time.Now(),
+		VideoTitle: strings.TrimSuffix(filename, filepath.Ext(filename)),
+		Source:     JobSourceUpload,
+	}
+
+	s.mu.Lock()
+	s.conversions[jobID] = job
+	s.mu.Unlock()
+
+	if err := database.SaveConversion(job); err != nil {
+		log.Printf("Failed to save job to database: %v", err)
+	}
+
+	return job
+}
+
+// ProcessUpload converts an uploaded file with the same ffmpeg pipeline as
+// downloaded videos.
+func (s *ConversionService) ProcessUpload(ctx context.Context, job *models.ConversionJob, inputPath string) {
+	job.Mu.Lock()
+	sanitizedTitle := sanitizeFilename(job.VideoTitle)
+	format := job.Format
+	job.Mu.Unlock()
+
 	if sanitizedTitle == "" {
 		sanitizedTitle = job.ID
 	}
 
+	s.convert(ctx, job, inputPath, sanitizedTitle, format)
+}
+
+// Enqueue queues a job to be resolved with the given quality and converted.
+func (s *ConversionService) Enqueue(job *models.ConversionJob, quality models.QualitySelection) {
+	job.Mu.Lock()
+	job.Quality = quality
+	job.Mu.Unlock()
+
+	s.enqueue(job)
+}
+
+// EnqueueMediaURL queues the conversion of a probed HTTP(S) link; there is
+// nothing to resolve.
+func (s *ConversionService) EnqueueMediaURL(job *models.ConversionJob, probe *MediaProbe) {
+	filename := mediaJobFilename(probe.Filename, job.ID)
+	title := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	job.Mu.Lock()
+	job.DownloadURL = probe.URL
+	job.VideoSize = probe.Size
+	job.BytesTotal = probe.Size
+	job.VideoTitle = title
+	job.Provider = MediaURLProvider
+	job.Mu.Unlock()
+
+	s.enqueue(job)
+}
+
+// EnqueueUpload queues the conversion of a file saved by SaveUpload.
+func (s *ConversionService) EnqueueUpload(job *models.ConversionJob) {
+	s.enqueue(job)
+}
+
+func (s *ConversionService) enqueue(job *models.ConversionJob) {
+	now := time.Now()
+
+	job.Mu.Lock()
+	job.Status = "queued"
+	job.QueuedAt = &now
+	database.SaveConversion(job)
+	job.Mu.Unlock()
+
+	s.queue.Submit(models.QueueKindConversion, job.ID, now)
+}
+
+// RunQueued runs a job once the queue gets to it, working out from the stored
+// job what has to be done.
+func (s *ConversionService) RunQueued(jobID string) {
+	// The context is taken along with the job, so GetAllJobs can't swap the
+	// job for a fresh copy from the database once it runs
+	s.mu.RLock()
+	job, exists := s.conversions[jobID]
+	var ctx context.Context
+	if exists {
+		ctx = s.contexts.get(jobID)
+	}
+	s.mu.RUnlock()
+
+	if !exists {
+		log.Printf("Job %s: queued job not found", jobID)
+		return
+	}
+
+	// The download slot is freed before the context, so once a paused job no
+	// longer holds its context it can be queued again
+	defer s.contexts.done(jobID)
+	defer s.queue.FinishDownload(models.QueueKindConversion, jobID)
+
+	job.Mu.Lock()
+	// The job was paused or cancelled between leaving the queue and starting
+	if stopped(job.Status) {
+		job.Mu.Unlock()
+		return
+	}
+	source, provider := job.Source, job.Provider
+	url, downloadURL, format, title := job.URL, job.DownloadURL, job.Format, job.VideoTitle
+	quality, inputPath := job.Quality, job.InputPath
+	job.Mu.Unlock()
+
+	switch {
+	case inputPath != "":
+		// The download already finished, for example before a pause or a
+		// restart, so only the conversion is left
+		s.convert(ctx, job, inputPath, jobFileTitle(jobID, title), format)
+	case source == JobSourceUpload:
+		s.ProcessUpload(ctx, job, s.uploadPath(jobID, url))
+	case provider == MediaURLProvider:
+		s.ProcessConversion(ctx, job, downloadURL, format, title)
+	default:
+		videoSource, videoID, err := s.sources.Lookup(url)
+		if err != nil {
+			s.markJobFailed(job, "Invalid video URL", err)
+			return
+		}
+
+		job.Mu.Lock()
+		if !stopped(job.Status) {
+			job.Status = "resolving"
+			database.SaveConversion(job)
+		}
+		job.Mu.Unlock()
+
+		s.ResolveAndProcess(ctx, job, videoSource, videoID, quality)
+	}
+}
+
+func (s *ConversionService) ProcessConversion(ctx context.Context, job *models.ConversionJob, downloadURL, format, videoTitle string) {
+	job.Mu.Lock()
+	if !stopped(job.Status) {
+		job.Status = "downloading"
+	}
+	job.Progress = 0.25
+	job.BytesDone = 0
+	job.TransferRate = 0
+	job.ETASeconds = 0
+	database.SaveConversion(job)
+	job.Mu.Unlock()
+
+	job.Mu.Lock()
+	audioURL := job.AudioURL
+	videoURLs := mirrorURLs(downloadURL, job.MirrorURL)
+	audioURLs := mirrorURLs(audioURL, job.AudioMirrorURL)
+	expectedSize := job.BytesTotal
+	throttle := s.Bandwidth.ForJob(job.MaxRate)
+	audioSize := job.AudioSize
+	provider := job.Provider
+	job.Mu.Unlock()
+
+	// Downloading takes the job from 25% to 50%
+	progress := newTransferProgress(expectedSize, func(snapshot TransferSnapshot) {
+		job.Mu.Lock()
+		job.BytesDone = snapshot.Done
+		job.BytesTotal = snapshot.Total
+		job.TransferRate = snapshot.Rate
+		job.ETASeconds = int(snapshot.ETA.Seconds())
+		job.Progress = 0.25 + 0.25*snapshot.Fraction()
+		database.SaveConversion(job)
+		job.Mu.Unlock()
+	})
+
+	// Media links were checked when submitted and are only fetched through
+	// their own client
+	var err error
+	if provider != MediaURLProvider {
+		err = s.prober.WaitUntilAllReady(ctx, [][]string{videoURLs, audioURLs}, func(state string) {
+			job.Mu.Lock()
+			job.FileState = state
+			database.SaveConversion(job)
+			job.Mu.Unlock()
+		})
+	}
+	if err != nil {
+		job.Mu.Lock()
+		url, itag := job.URL, job.Itag
+		job.Mu.Unlock()
+		forgetResolution(url, itag)
+		s.markJobFailed(job, "Video file never became available", err)
+		log.Printf("Job %s failed: %v", job.ID, err)
+		return
+	}
+
+	// Use video title for filename (sanitize it)
+	sanitizedTitle := jobFileTitle(job.ID, videoTitle)
+
 	tempFile := filepath.Join(s.ongoingDir, sanitizedTitle+".mp4")
+	videoFile := filepath.Join(s.ongoingDir, sanitizedTitle+".video")
+	audioFile := filepath.Join(s.ongoingDir, sanitizedTitle+".audio")
+	if audioURL != "" {
+		tempFile = filepath.Join(s.ongoingDir, sanitizedTitle+".mkv")
+	}
 
-	// Download with retries
-	if err := s.downloadWithRetries(downloadURL, tempFile, job); err != nil {
-		s.markJobFailed(job, "Failed to download video: "+err.Error())
+	// A cancelled job doesn't leave partial downloads behind, while a paused
+	// one keeps them to resume from
+	defer func() {
+		if ctx.Err() != nil && s.jobStatus(job) == StatusCancelled {
+			s.removePartialFiles(job)
+		}
+	}()
+
+	// Download with retries. Video-only streams are fetched alongside their
+	// audio and muxed first so the converted output has sound.
+	if audioURL != "" {
+		if err := s.downloadWithRetries(ctx, videoURLs, videoFile, job, progress, throttle); err != nil {
+			s.reportFailure(ctx, job)
+			s.markJobFailed(job, "Failed to download video", err)
+			log.Printf("Job %s failed: %v", job.ID, err)
+			return
+		}
+		// The audio stream is small enough to just fetch again
+		audioTask := &downloadTask{URLs: audioURLs, OutputPath: audioFile, LogPrefix: "Job " + job.ID, Progress: progress, Throttle: throttle, ExpectedSize: audioSize}
+		i, err := fetchWithMirrors(ctx, audioTask)
+		if err != nil {
+			os.Remove(audioFile)
+			s.reportFailure(ctx, job)
+			s.markJobFailed(job, "Failed to download audio", err)
+			log.Printf("Job %s failed: %v", job.ID, err)
+			return
+		}
+		job.Mu.Lock()
+		job.AudioServedFrom = servedFrom(i)
+		database.SaveConversion(job)
+		job.Mu.Unlock()
+		if err := s.muxStreams(ctx, job, videoFile, audioFile, tempFile); err != nil {
+			s.markJobFailed(job, "Failed to merge audio and video", err)
+			log.Printf("Job %s failed: %v", job.ID, err)
+			return
+		}
+	} else if err := s.downloadWithRetries(ctx, videoURLs, tempFile, job, progress, throttle); err != nil {
+		s.reportFailure(ctx, job)
+		s.markJobFailed(job, "Failed to download video", err)
 		log.Printf("Job %s failed: %v", job.ID, err)
 		return
 	}
 
+	s.convert(ctx, job, tempFile, sanitizedTitle, format)
+}
+
+// muxStreams merges a job's streams with MuxStreams. Once the downloaded
+// streams are gone there is nothing left to resume from.
+func (s *ConversionService) muxStreams(ctx context.Context, job *models.ConversionJob, videoFile, audioFile, outputFile string) error {
+	job.Mu.Lock()
+	if !stopped(job.Status) {
+		job.Status = StatusMuxing
+	}
+	database.SaveConversion(job)
+	job.Mu.Unlock()
+
+	err := MuxStreams(ctx, videoFile, audioFile, outputFile)
+	if ctx.Err() == nil {
+		job.Mu.Lock()
+		job.ResumeOffset = 0
+		job.ResumeETag = ""
+		database.SaveConversion(job)
+		job.Mu.Unlock()
+	}
+	return err
+}
+
+// reportFailure marks the provider as failing and drops the URL it handed
+// out from the cache, unless the download stopped because the job was
+// cancelled.
+func (s *ConversionService) reportFailure(ctx context.Context, job *models.ConversionJob) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	job.Mu.Lock()
+	provider, url, itag := job.Provider, job.URL, job.Itag
+	job.Mu.Unlock()
+
+	s.sources.ReportFailure(provider)
+	forgetResolution(url, itag)
+}
+
+// convert checks that tempFile is playable, runs ffmpeg on it and publishes
+// the verified output to the completed directory. The input is removed
+// either way, unless the job is paused or the process stops; InputPath keeps
+// it for the next run until then.
+func (s *ConversionService) convert(ctx context.Context, job *models.ConversionJob, tempFile, sanitizedTitle, format string) {
+	job.Mu.Lock()
+	job.InputPath = tempFile
+	database.SaveConversion(job)
+	job.Mu.Unlock()
+
+	// The input is on disk, so the next job can start downloading while
+	// this one waits for ffmpeg
+	s.queue.FinishDownload(models.QueueKindConversion, job.ID)
+	if err := s.queue.AcquireTranscode(ctx); err != nil {
+		s.markJobFailed(job, "Conversion stopped", err)
+		// A paused job converts the file once it is resumed
+		if s.jobStatus(job) != StatusPaused {
+			s.removeInput(job)
+		}
+		return
+	}
+	defer s.queue.ReleaseTranscode()
+
 	job.Mu.Lock()
-	job.Status = "converting"
+	if !stopped(job.Status) {
+		job.Status = "converting"
+	}
 	job.Progress = 0.5
 	database.SaveConversion(job)
 	job.Mu.Unlock()
 
-	outputFile := filepath.Join(s.completedDir, sanitizedTitle+"."+format)
+	if err := checkPlayable(tempFile); err != nil {
+		s.markJobFailed(job, "Downloaded file failed verification", err)
+		log.Printf("Job %s failed: %v", job.ID, err)
+		s.removeInput(job)
+		return
+	}
+
+	// ffmpeg writes into the ongoing directory so a half-written output is
+	// never served
+	filename := sanitizedTitle + "." + format
+	outputFile := filepath.Join(s.ongoingDir, sanitizedTitle+".converted."+format)
 
-	cmd := utils.BuildFFmpegCommand(tempFile, outputFile, format)
+	cmd := utils.BuildFFmpegCommand(ctx, tempFile, outputFile, format)
 
 	if err := cmd.Run(); err != nil {
-		s.markJobFailed(job, "FFmpeg conversion failed: "+err.Error())
+		// ffmpeg was killed because the job was cancelled
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		s.markJobFailed(job, "FFmpeg conversion failed", err)
 		log.Printf("Job %s failed: %v", job.ID, err)
-		os.Remove(tempFile)
+		s.removeInput(job)
+		os.Remove(outputFile)
 		return
 	}
 
-	os.Remove(tempFile)
+	s.removeInput(job)
+
+	sum, err := PublishFile(outputFile, filepath.Join(s.completedDir, filename))
+	if err != nil {
+		s.markJobFailed(job, "Converted file failed verification", err)
+		log.Printf("Job %s failed: %v", job.ID, err)
+		return
+	}
 
 	job.Mu.Lock()
 	job.Status = "completed"
 	job.Progress = 1.0
+	job.SHA256 = sum
 	endTime := time.Now()
 	job.EndTime = &endTime
-	filename := sanitizedTitle + "." + format
 	job.Filename = &filename
 	database.SaveConversion(job)
+	batchID := job.BatchID
 	job.Mu.Unlock()
 
+	updateBatchProgress(batchID)
+
 	log.Printf("Job %s: Conversion completed", job.ID)
 }
 
-func (s *ConversionService) downloadWithRetries(downloadURL, outputPath string, job *models.ConversionJob) error {
-	var resp *http.Response
-	var err error
-	maxRetries := 3
-
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		resp, err = http.Get(downloadURL)
-		if err == nil {
-			break
-		}
-		log.Printf("Job %s: Download attempt %d failed: %v", job.ID, attempt+1, err)
-		if attempt < maxRetries-1 {
-			time.Sleep(time.Duration(5*(attempt+1)) * time.Second)
-		}
+// downloadWithRetries fetches the video stream, falling back to the
+// provider's mirror when the primary URL fails, and records which one served
+// the file. The partial file and its offset are kept on failure so a retry,
+// even after a restart, resumes where this attempt stopped.
+func (s *ConversionService) downloadWithRetries(ctx context.Context, urls []string, outputPath string, job *models.ConversionJob, progress *transferProgress, throttle *throttle) error {
+	job.Mu.Lock()
+	task := &downloadTask{
+		URLs:         urls,
+		OutputPath:   outputPath,
+		LogPrefix:    "Job " + job.ID,
+		Offset:       job.ResumeOffset,
+		ETag:         job.ResumeETag,
+		Segments:     job.Segments,
+		Progress:     progress,
+		Throttle:     throttle,
+		ExpectedSize: job.VideoSize,
 	}
-
-	if err != nil {
-		return err
+	if job.Provider == MediaURLProvider {
+		s.MediaURLs.restrict(task)
 	}
-	defer resp.Body.Close()
+	job.Mu.Unlock()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status code: %d", resp.StatusCode)
+	if task.Segments == 0 {
+		task.Segments = s.DownloadSegments
 	}
 
-	out, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+	task.Checkpoint = func(offset int64, etag string) {
+		job.Mu.Lock()
+		job.ResumeOffset = offset
+		job.ResumeETag = etag
+		database.SaveConversion(job)
+		job.Mu.Unlock()
 	}
-	defer out.Close()
 
-	size, err := io.Copy(out, resp.Body)
+	i, err := fetchWithMirrors(ctx, task)
 	if err != nil {
-		return fmt.Errorf("failed to save video: %w", err)
+		return err
 	}
 
-	log.Printf("Job %s: Downloaded %d bytes", job.ID, size)
+	job.Mu.Lock()
+	job.ServedFrom = servedFrom(i)
+	database.SaveConversion(job)
+	job.Mu.Unlock()
 	return nil
 }
 
-func (s *ConversionService) markJobFailed(job *models.ConversionJob, errorMsg string) {
+// markJobFailed stores the failure on the job, along with the error code
+// when err is a typed provider error. Jobs stopped by CancelJob are marked
+// cancelled instead.
+func (s *ConversionService) markJobFailed(job *models.ConversionJob, reason string, err error) {
+	if errors.Is(err, context.Canceled) {
+		if s.jobStatus(job) == StatusPaused {
+			log.Printf("Job %s: Paused", job.ID)
+			return
+		}
+		s.markJobCancelled(job)
+		log.Printf("Job %s: Cancelled", job.ID)
+		return
+	}
+
+	errorMsg := reason + ": " + err.Error()
+
 	job.Mu.Lock()
+	// Whatever went wrong after a pause or cancel doesn't matter any more
+	if stopped(job.Status) {
+		job.Mu.Unlock()
+		return
+	}
 	job.Status = "failed"
 	job.Error = &errorMsg
+	job.ErrorCode = ErrorCode(err)
 	endTime := time.Now()
 	job.EndTime = &endTime
 	database.SaveConversion(job)
+	batchID := job.BatchID
+	job.Mu.Unlock()
+
+	updateBatchProgress(batchID)
+}
+
+// markJobCancelled ends a job as cancelled. Its partial files are gone, so
+// the resume offset is dropped too.
+func (s *ConversionService) markJobCancelled(job *models.ConversionJob) {
+	job.Mu.Lock()
+	job.Status = StatusCancelled
+	job.Error = nil
+	job.ErrorCode = ""
+	job.ResumeOffset = 0
+	job.ResumeETag = ""
+	job.TransferRate = 0
+	job.ETASeconds = 0
+	endTime := time.Now()
+	job.EndTime = &endTime
+	database.SaveConversion(job)
+	batchID := job.BatchID
+	job.Mu.Unlock()
+
+	updateBatchProgress(batchID)
+}
+
+// CancelJob stops a queued or running job: its download is aborted, ffmpeg
+// is killed and partial files are removed. The job ends up "cancelled".
+func (s *ConversionService) CancelJob(jobID string) error {
+	job, exists := s.GetJob(jobID)
+	if !exists {
+		return ErrJobNotFound
+	}
+
+	if status := s.jobStatus(job); finished(status) {
+		return fmt.Errorf("%w: %s", ErrJobFinished, status)
+	}
+
+	s.queue.Remove(models.QueueKindConversion, jobID)
+
+	// The status is set before the context is cancelled, so RunQueued either
+	// sees it or gets a cancelled context. A job that isn't running, such as
+	// a queued or paused one, is cleaned up here.
+	s.markJobCancelled(job)
+	if !s.contexts.cancel(jobID) {
+		s.removePartialFiles(job)
+	}
+
+	log.Printf("Job %s: Cancel requested", jobID)
+	return nil
+}
+
+// PauseJob stops a queued or downloading job and frees its download slot.
+// The partial file and its offset are kept, so ResumeJob continues from
+// there, even after a restart.
+func (s *ConversionService) PauseJob(jobID string) error {
+	job, exists := s.GetJob(jobID)
+	if !exists {
+		return ErrJobNotFound
+	}
+
+	job.Mu.Lock()
+	if !pausable(job.Status) {
+		status := job.Status
+		job.Mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobNotPausable, status)
+	}
+	job.Status = StatusPaused
+	job.TransferRate = 0
+	job.ETASeconds = 0
+	database.SaveConversion(job)
+	job.Mu.Unlock()
+
+	s.queue.Remove(models.QueueKindConversion, jobID)
+	s.contexts.cancel(jobID)
+
+	log.Printf("Job %s: Pause requested", jobID)
+	return nil
+}
+
+// ResumeJob queues a paused job again. Provider URLs are resolved again for
+// the same stream, and the download continues from the saved offset.
+func (s *ConversionService) ResumeJob(jobID string) error {
+	job, exists := s.GetJob(jobID)
+	if !exists {
+		return ErrJobNotFound
+	}
+
+	if status := s.jobStatus(job); status != StatusPaused {
+		return fmt.Errorf("%w: %s", ErrJobNotPaused, status)
+	}
+	if s.contexts.running(jobID) {
+		return ErrJobStopping
+	}
+
+	job.Mu.Lock()
+	if job.Itag != 0 {
+		job.Quality = models.QualitySelection{Itag: job.Itag}
+	}
 	job.Mu.Unlock()
+
+	s.enqueue(job)
+
+	log.Printf("Job %s: Resumed", jobID)
+	return nil
 }
 
+func (s *ConversionService) jobStatus(job *models.ConversionJob) string {
+	job.Mu.Lock()
+	defer job.Mu.Unlock()
+	return job.Status
+}
+
+// removeInput deletes the file a job was converting and forgets it.
+func (s *ConversionService) removeInput(job *models.ConversionJob) {
+	job.Mu.Lock()
+	inputPath := job.InputPath
+	job.InputPath = ""
+	database.SaveConversion(job)
+	job.Mu.Unlock()
+
+	if inputPath != "" {
+		os.Remove(inputPath)
+	}
+}
+
+// removePartialFiles deletes whatever a job that won't finish left in the
+// ongoing directory: its upload, its partial downloads, or the downloaded
+// file it was converting.
+func (s *ConversionService) removePartialFiles(job *models.ConversionJob) {
+	s.removeInput(job)
+
+	job.Mu.Lock()
+	source, url, title := job.Source, job.URL, job.VideoTitle
+	job.Mu.Unlock()
+
+	if source == JobSourceUpload {
+		os.Remove(s.uploadPath(job.ID, url))
+		return
+	}
+
+	sanitizedTitle := jobFileTitle(job.ID, title)
+	for _, ext := range []string{".mp4", ".mkv", ".video", ".audio"} {
+		os.Remove(filepath.Join(s.ongoingDir, sanitizedTitle+ext))
+	}
+}
+
+// jobFileTitle names a job's files after its video, or its ID when the title
+// is unknown.
+func jobFileTitle(jobID, videoTitle string) string {
+	if sanitizedTitle := sanitizeFilename(videoTitle); sanitizedTitle != "" {
+		return sanitizedTitle
+	}
+	return jobID
+}
+
+// ErrJobNotRetryable is returned when retrying a job that hasn't failed.
+var ErrJobNotRetryable = errors.New("only failed or interrupted jobs can be retried")
+
+// RetryJob queues a failed or interrupted job again. A paused job is
+// resumed instead.
 func (s *ConversionService) RetryJob(jobID string) error {
 	job, exists := s.GetJob(jobID)
 	if !exists {
-		return fmt.Errorf("job not found")
+		return ErrJobNotFound
 	}
 
-	if job.DownloadURL == "" {
-		return fmt.Errorf("cannot retry: no download URL available")
+	job.Mu.Lock()
+	switch job.Status {
+	case "failed", StatusInterrupted:
+	case StatusPaused:
+		job.Mu.Unlock()
+		return s.ResumeJob(jobID)
+	default:
+		status := job.Status
+		job.Mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobNotRetryable, status)
+	}
+	if code := job.ErrorCode; !Retryable(code) {
+		job.Mu.Unlock()
+		return fmt.Errorf("cannot retry: %s", ErrorMessage(code))
+	}
+	// The uploaded file is removed once ffmpeg has run
+	if job.Source == JobSourceUpload {
+		job.Mu.Unlock()
+		return fmt.Errorf("cannot retry: uploaded files must be uploaded again")
+	}
+	isMediaURL := job.Provider == MediaURLProvider
+	job.Mu.Unlock()
+
+	// Plain media links are fetched again as they are
+	if isMediaURL {
+		job.Mu.Lock()
+		job.Error = nil
+		job.ErrorCode = ""
+		job.Progress = 0
+		job.BytesDone = 0
+		job.TransferRate = 0
+		job.ETASeconds = 0
+		job.StartTime = time.Now()
+		job.EndTime = nil
+		job.Mu.Unlock()
+
+		s.enqueue(job)
+		return nil
+	}
+
+	// Provider URLs are signed and expire, so resolve again. The stored
+	// itag makes sure we fetch the same stream as the first attempt, and the
+	// provider that served the failed attempt is tried last.
+	_, videoID, err := s.sources.Lookup(job.URL)
+	if err != nil {
+		return fmt.Errorf("cannot retry: %w", err)
 	}
 
 	job.Mu.Lock()
-	job.Status = "downloading"
+	failedProvider := job.Provider
 	job.Error = nil
-	job.Progress = 0.25
+	job.ErrorCode = ""
+	job.Progress = 0
+	job.BytesDone = 0
+	job.TransferRate = 0
+	job.ETASeconds = 0
 	job.StartTime = time.Now()
 	job.EndTime = nil
-	database.SaveConversion(job)
-
-	videoTitle := job.VideoTitle
-	if videoTitle == "" {
-		videoTitle = jobID
+	if job.Itag != 0 {
+		job.Quality = models.QualitySelection{Itag: job.Itag}
 	}
 	job.Mu.Unlock()
 
-	go s.ProcessConversion(job, job.DownloadURL, job.Format, videoTitle)
+	s.sources.Demote(videoID, failedProvider)
+	s.enqueue(job)
 
 	return nil
 }
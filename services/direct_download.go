@@ -1,12 +1,13 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,38 +16,107 @@ import (
 )
 
 type DirectDownloadService struct {
-	downloads     map[string]*models.DirectDownload
-	mu            sync.RWMutex
-	tempDir       string
-	completedDir  string
+	// DownloadSegments is how many parallel connections fetch a video when
+	// the download doesn't say.
+	DownloadSegments int
+	// Bandwidth, when set, limits how fast videos are downloaded
+	Bandwidth *BandwidthLimiter
+	// MediaURLs, when set, restricts how direct media links are fetched
+	MediaURLs *MediaURLService
+
+	downloads map[string]*models.DirectDownload
+	// mu guards downloads and the records in it. Records are saved with mu
+	// held, as gorm reads every field while workers keep updating them.
+	mu           sync.RWMutex
+	tempDir      string
+	completedDir string
+	prober       *ReadinessProber
+	sources      *SourceRegistry
+	queue        *JobQueue
+	contexts     *jobContexts
 }
 
-func NewDirectDownloadService(tempDir, completedDir string) *DirectDownloadService {
+func NewDirectDownloadService(tempDir, completedDir string, prober *ReadinessProber, sources *SourceRegistry, queue *JobQueue) *DirectDownloadService {
 	return &DirectDownloadService{
 		downloads:    make(map[string]*models.DirectDownload),
 		tempDir:      tempDir,
 		completedDir: completedDir,
+		prober:       prober,
+		sources:      sources,
+		queue:        queue,
+		contexts:     newJobContexts(),
 	}
 }
 
-func (s *DirectDownloadService) CreateDownload(id, url, filename string) *models.DirectDownload {
+// CreateDownload records a new download in the "resolving" state until it
+// is queued. The filename is set once the video title is known. batchID is empty for
+// downloads that aren't part of a playlist. maxRate caps the download speed in
+// bytes per second, 0 for no cap of its own.
+func (s *DirectDownloadService) CreateDownload(id, url, batchID string, segments int, maxRate int64) *models.DirectDownload {
+	download, err := s.createDownload(id, url, batchID, segments, maxRate)
+	if err != nil {
+		log.Printf("Failed to save download to database: %v", err)
+		// The download can still run, it just won't survive a restart
+		s.mu.Lock()
+		s.downloads[id] = download
+		s.mu.Unlock()
+	}
+	return download
+}
+
+// createDownload is CreateDownload for callers that need the download to be
+// saved. A download that can't be saved isn't tracked.
+func (s *DirectDownloadService) createDownload(id, url, batchID string, segments int, maxRate int64) (*models.DirectDownload, error) {
 	download := &models.DirectDownload{
 		ID:           id,
 		URL:          url,
-		Filename:     filename,
 		DownloadTime: time.Now(),
-		Status:       "processing",
+		Status:       "resolving",
+		Segments:     segments,
+		MaxRate:      maxRate,
+	}
+	if batchID != "" {
+		download.BatchID = &batchID
+	}
+
+	if err := database.SaveDirectDownload(download); err != nil {
+		return download, err
 	}
 
 	s.mu.Lock()
 	s.downloads[id] = download
 	s.mu.Unlock()
 
-	if err := database.SaveDirectDownload(download); err != nil {
-		log.Printf("Failed to save download to database: %v", err)
+	return download, nil
+}
+
+// loadDownload returns a download from memory, loading it from the database
+// when it was queued before a restart.
+func (s *DirectDownloadService) loadDownload(id string) (*models.DirectDownload, error) {
+	if download, exists := s.GetDownload(id); exists {
+		return download, nil
 	}
 
-	return download
+	stored, err := database.GetDirectDownload(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// RunQueued may have loaded it in the meantime
+	if download, exists := s.downloads[id]; exists {
+		return download, nil
+	}
+	s.downloads[id] = stored
+	return stored, nil
+}
+
+// DownloadExists reports whether a download is known, in memory or only in
+// the database.
+func (s *DirectDownloadService) DownloadExists(id string) bool {
+	_, err := s.loadDownload(id)
+	return err == nil
 }
 
 func (s *DirectDownloadService) GetDownload(id string) (*models.DirectDownload, bool) {
@@ -56,76 +126,609 @@ func (s *DirectDownloadService) GetDownload(id string) (*models.DirectDownload,
 	return download, exists
 }
 
-func (s *DirectDownloadService) ProcessDownload(download *models.DirectDownload, downloadURL string) {
+// ResolveAndProcess asks the source for a download URL and then fetches the
+// file. Resolution failures are stored on the download.
+func (s *DirectDownloadService) ResolveAndProcess(ctx context.Context, download *models.DirectDownload, source VideoSource, videoID string, quality models.QualitySelection) {
+	media, err := resolveMedia(source, videoID, quality)
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		s.markDownloadFailed(download, "Failed to get download URL", err)
+		log.Printf("Download %s failed to resolve: %v", download.ID, err)
+		return
+	}
+
+	sanitizedTitle := sanitizeFilename(media.Title)
+	if sanitizedTitle == "" {
+		sanitizedTitle = videoID
+	}
+
+	s.mu.Lock()
+	download.Filename = sanitizedTitle + "." + streamExtension(media.Stream)
+	download.Itag = media.Stream.Itag
+	download.Provider = media.Stream.Provider
+	download.MirrorURL = media.Stream.ReservedFile
+	download.VideoSize = media.Stream.Size
+	download.AudioSize = 0
+	download.BytesTotal = media.Stream.Size
+	download.AudioURL = ""
+	download.AudioMirrorURL = ""
+	if media.Audio != nil {
+		download.AudioURL = media.Audio.File
+		download.AudioMirrorURL = media.Audio.ReservedFile
+		download.AudioSize = media.Audio.Size
+		download.BytesTotal += media.Audio.Size
+	}
+	download.ServedFrom = ""
+	download.AudioServedFrom = ""
+	if !stopped(download.Status) {
+		download.Status = "processing"
+	}
+	download.UpdatedAt = time.Now()
+	err = database.SaveDirectDownload(download)
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to update download in database: %v", err)
+	}
+
+	s.ProcessDownload(ctx, download, media.Stream.File)
+}
+
+// Enqueue queues a download to be resolved with the given quality.
+func (s *DirectDownloadService) Enqueue(download *models.DirectDownload, quality models.QualitySelection) {
+	s.mu.Lock()
+	download.Quality = quality
+	s.mu.Unlock()
+
+	s.enqueue(download)
+}
+
+// EnqueueMediaURL queues a probed HTTP(S) link, which is downloaded as is;
+// there is nothing to resolve.
+func (s *DirectDownloadService) EnqueueMediaURL(download *models.DirectDownload, probe *MediaProbe) {
+	s.mu.Lock()
+	download.Filename = mediaJobFilename(probe.Filename, download.ID)
+	download.VideoSize = probe.Size
+	download.BytesTotal = probe.Size
+	download.Provider = MediaURLProvider
+	s.mu.Unlock()
+
+	s.enqueue(download)
+}
+
+func (s *DirectDownloadService) enqueue(download *models.DirectDownload) {
+	now := time.Now()
+
+	s.mu.Lock()
+	download.Status = "queued"
+	download.QueuedAt = &now
+	download.UpdatedAt = now
+	err := database.SaveDirectDownload(download)
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to update download in database: %v", err)
+	}
+
+	s.queue.Submit(models.QueueKindDownload, download.ID, now)
+}
+
+// RunQueued runs a download once the queue gets to it. Downloads queued
+// before a restart are loaded from the database.
+func (s *DirectDownloadService) RunQueued(id string) {
+	download, err := s.loadDownload(id)
+	if err != nil {
+		log.Printf("Download %s: queued download not found: %v", id, err)
+		return
+	}
+
+	// The download slot is freed before the context, so once a paused
+	// download no longer holds its context it can be queued again
+	ctx := s.contexts.get(id)
+	defer s.contexts.done(id)
+	defer s.queue.FinishDownload(models.QueueKindDownload, id)
+
+	s.mu.RLock()
+	status := download.Status
+	url, provider, quality := download.URL, download.Provider, download.Quality
+	s.mu.RUnlock()
+
+	// The download was paused or cancelled between leaving the queue and
+	// starting
+	if stopped(status) {
+		return
+	}
+
+	if provider == MediaURLProvider {
+		s.setStatus(download, "processing")
+		s.ProcessDownload(ctx, download, url)
+		return
+	}
+
+	source, videoID, err := s.sources.Lookup(url)
+	if err != nil {
+		s.markDownloadFailed(download, "Invalid video URL", err)
+		return
+	}
+
+	s.setStatus(download, "resolving")
+
+	s.ResolveAndProcess(ctx, download, source, videoID, quality)
+}
+
+func (s *DirectDownloadService) ProcessDownload(ctx context.Context, download *models.DirectDownload, downloadURL string) {
+	s.mu.Lock()
+	audioURL := download.AudioURL
+	videoURLs := mirrorURLs(downloadURL, download.MirrorURL)
+	audioURLs := mirrorURLs(audioURL, download.AudioMirrorURL)
+	expectedSize := download.BytesTotal
+	throttle := s.Bandwidth.ForJob(download.MaxRate)
+	audioSize := download.AudioSize
+	provider := download.Provider
+	download.Progress = 0
+	download.BytesDone = 0
+	download.TransferRate = 0
+	download.ETASeconds = 0
+	s.mu.Unlock()
+
+	progress := newTransferProgress(expectedSize, func(snapshot TransferSnapshot) {
+		s.mu.Lock()
+		download.BytesDone = snapshot.Done
+		download.BytesTotal = snapshot.Total
+		download.TransferRate = snapshot.Rate
+		download.ETASeconds = int(snapshot.ETA.Seconds())
+		download.Progress = snapshot.Fraction()
+		download.UpdatedAt = time.Now()
+		database.SaveDirectDownload(download)
+		s.mu.Unlock()
+	})
+
+	// Media links were checked when submitted and are only fetched through
+	// their own client
+	var err error
+	if provider != MediaURLProvider {
+		err = s.prober.WaitUntilAllReady(ctx, [][]string{videoURLs, audioURLs}, func(state string) {
+			s.mu.Lock()
+			download.FileState = state
+			download.UpdatedAt = time.Now()
+			database.SaveDirectDownload(download)
+			s.mu.Unlock()
+		})
+	}
+	if err != nil {
+		s.mu.Lock()
+		url, itag := download.URL, download.Itag
+		s.mu.Unlock()
+		forgetResolution(url, itag)
+		s.markDownloadFailed(download, "Video file never became available", err)
+		log.Printf("Download %s failed: %v", download.ID, err)
+		return
+	}
+
 	// Create temp file path
 	tempFile := filepath.Join(s.tempDir, download.Filename)
+	videoFile := tempFile + ".video"
+	audioFile := tempFile + ".audio"
+
+	// A cancelled download doesn't leave partial files behind, while a
+	// paused one keeps them to resume from
+	defer func() {
+		if ctx.Err() != nil && s.downloadStatus(download) == StatusCancelled {
+			s.removePartialFiles(download)
+		}
+	}()
 
-	// Download the file
-	if err := s.downloadFile(downloadURL, tempFile, download); err != nil {
-		s.markDownloadFailed(download, "Failed to download video: "+err.Error())
+	// Download the file. Video-only streams are muxed with their audio so
+	// the saved file has sound.
+	if audioURL != "" {
+		if err := s.downloadFile(ctx, videoURLs, videoFile, download, progress, throttle); err != nil {
+			s.reportFailure(ctx, download)
+			s.markDownloadFailed(download, "Failed to download video", err)
+			log.Printf("Download %s failed: %v", download.ID, err)
+			return
+		}
+		// The audio stream is small enough to just fetch again
+		audioTask := &downloadTask{URLs: audioURLs, OutputPath: audioFile, LogPrefix: "Download " + download.ID, Progress: progress, Throttle: throttle, ExpectedSize: audioSize}
+		i, err := fetchWithMirrors(ctx, audioTask)
+		if err != nil {
+			os.Remove(audioFile)
+			s.reportFailure(ctx, download)
+			s.markDownloadFailed(download, "Failed to download audio", err)
+			log.Printf("Download %s failed: %v", download.ID, err)
+			return
+		}
+		s.mu.Lock()
+		download.AudioServedFrom = servedFrom(i)
+		download.UpdatedAt = time.Now()
+		database.SaveDirectDownload(download)
+		s.mu.Unlock()
+		if err := s.muxStreams(ctx, download, videoFile, audioFile, tempFile); err != nil {
+			s.markDownloadFailed(download, "Failed to merge audio and video", err)
+			log.Printf("Download %s failed: %v", download.ID, err)
+			return
+		}
+	} else if err := s.downloadFile(ctx, videoURLs, tempFile, download, progress, throttle); err != nil {
+		s.reportFailure(ctx, download)
+		s.markDownloadFailed(download, "Failed to download video", err)
 		log.Printf("Download %s failed: %v", download.ID, err)
 		return
 	}
 
-	// Move to completed directory
+	// Move to completed directory once the file checks out
 	completedFile := filepath.Join(s.completedDir, download.Filename)
-	if err := os.Rename(tempFile, completedFile); err != nil {
-		s.markDownloadFailed(download, "Failed to move file: "+err.Error())
-		log.Printf("Download %s failed to move file: %v", download.ID, err)
-		os.Remove(tempFile)
+	sum, err := PublishFile(tempFile, completedFile)
+	if err != nil {
+		s.markDownloadFailed(download, "Downloaded file failed verification", err)
+		log.Printf("Download %s failed verification: %v", download.ID, err)
 		return
 	}
 
 	// Mark as completed
 	s.mu.Lock()
 	download.Status = "completed"
+	download.Progress = 1.0
+	download.SHA256 = sum
 	download.Error = nil
+	download.ErrorCode = ""
 	download.UpdatedAt = time.Now()
+	err = database.SaveDirectDownload(download)
 	s.mu.Unlock()
 
-	if err := database.SaveDirectDownload(download); err != nil {
+	if err != nil {
 		log.Printf("Failed to update download in database: %v", err)
 	}
 
+	updateBatchProgress(download.BatchID)
+
 	log.Printf("Download %s: Completed successfully", download.ID)
 }
 
-func (s *DirectDownloadService) downloadFile(downloadURL, outputPath string, download *models.DirectDownload) error {
-	resp, err := http.Get(downloadURL)
+// reportFailure marks the provider as failing and drops the URL it handed
+// out from the cache, unless the download stopped because it was cancelled.
+func (s *DirectDownloadService) reportFailure(ctx context.Context, download *models.DirectDownload) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	s.mu.Lock()
+	provider, url, itag := download.Provider, download.URL, download.Itag
+	s.mu.Unlock()
+
+	s.sources.ReportFailure(provider)
+	forgetResolution(url, itag)
+}
+
+// muxStreams merges a download's streams with MuxStreams. Once the
+// downloaded streams are gone there is nothing left to resume from.
+func (s *DirectDownloadService) muxStreams(ctx context.Context, download *models.DirectDownload, videoFile, audioFile, outputFile string) error {
+	s.setStatus(download, StatusMuxing)
+
+	err := MuxStreams(ctx, videoFile, audioFile, outputFile)
+	if ctx.Err() == nil {
+		s.mu.Lock()
+		download.ResumeOffset = 0
+		download.ResumeETag = ""
+		download.UpdatedAt = time.Now()
+		database.SaveDirectDownload(download)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// downloadFile fetches the video stream, falling back to the provider's
+// mirror when the primary URL fails, and records which one served the file.
+// The partial file and its offset are kept on failure so the download can be
+// resumed.
+func (s *DirectDownloadService) downloadFile(ctx context.Context, urls []string, outputPath string, download *models.DirectDownload, progress *transferProgress, throttle *throttle) error {
+	s.mu.RLock()
+	task := &downloadTask{
+		URLs:         urls,
+		OutputPath:   outputPath,
+		LogPrefix:    "Download " + download.ID,
+		Offset:       download.ResumeOffset,
+		ETag:         download.ResumeETag,
+		Segments:     download.Segments,
+		Progress:     progress,
+		Throttle:     throttle,
+		ExpectedSize: download.VideoSize,
+	}
+	if download.Provider == MediaURLProvider {
+		s.MediaURLs.restrict(task)
+	}
+	s.mu.RUnlock()
+
+	if task.Segments == 0 {
+		task.Segments = s.DownloadSegments
+	}
+
+	task.Checkpoint = func(offset int64, etag string) {
+		s.mu.Lock()
+		download.ResumeOffset = offset
+		download.ResumeETag = etag
+		download.UpdatedAt = time.Now()
+		database.SaveDirectDownload(download)
+		s.mu.Unlock()
+	}
+
+	i, err := fetchWithMirrors(ctx, task)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status code: %d", resp.StatusCode)
+	s.mu.Lock()
+	download.ServedFrom = servedFrom(i)
+	download.UpdatedAt = time.Now()
+	database.SaveDirectDownload(download)
+	s.mu.Unlock()
+	return nil
+}
+
+// markDownloadFailed stores the failure on the download, along with the
+// error code when err is a typed provider error. Downloads stopped by
+// CancelDownload are marked cancelled instead.
+func (s *DirectDownloadService) markDownloadFailed(download *models.DirectDownload, reason string, err error) {
+	if errors.Is(err, context.Canceled) {
+		if s.downloadStatus(download) == StatusPaused {
+			log.Printf("Download %s: Paused", download.ID)
+			return
+		}
+		s.markDownloadCancelled(download)
+		log.Printf("Download %s: Cancelled", download.ID)
+		return
+	}
+
+	errorMsg := reason + ": " + err.Error()
+
+	s.mu.Lock()
+	// Whatever went wrong after a pause or cancel doesn't matter any more
+	if stopped(download.Status) {
+		s.mu.Unlock()
+		return
+	}
+	download.Status = "failed"
+	download.Error = &errorMsg
+	download.ErrorCode = ErrorCode(err)
+	download.UpdatedAt = time.Now()
+	err = database.SaveDirectDownload(download)
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to update download in database: %v", err)
 	}
 
-	out, err := os.Create(outputPath)
+	updateBatchProgress(download.BatchID)
+}
+
+// markDownloadCancelled ends a download as cancelled. Its partial files are
+// gone, so the resume offset is dropped too.
+func (s *DirectDownloadService) markDownloadCancelled(download *models.DirectDownload) {
+	s.mu.Lock()
+	download.Status = StatusCancelled
+	download.Error = nil
+	download.ErrorCode = ""
+	download.ResumeOffset = 0
+	download.ResumeETag = ""
+	download.TransferRate = 0
+	download.ETASeconds = 0
+	download.UpdatedAt = time.Now()
+	err := database.SaveDirectDownload(download)
+	s.mu.Unlock()
+
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		log.Printf("Failed to update download in database: %v", err)
 	}
-	defer out.Close()
 
-	size, err := io.Copy(out, resp.Body)
+	updateBatchProgress(download.BatchID)
+}
+
+// CancelDownload stops a queued or running download and removes its partial
+// files. The download ends up "cancelled".
+func (s *DirectDownloadService) CancelDownload(id string) error {
+	download, err := s.loadDownload(id)
 	if err != nil {
-		return fmt.Errorf("failed to save video: %w", err)
+		return ErrJobNotFound
 	}
 
-	log.Printf("Download %s: Downloaded %d bytes", download.ID, size)
+	if status := s.downloadStatus(download); finished(status) {
+		return fmt.Errorf("%w: %s", ErrJobFinished, status)
+	}
+
+	s.queue.Remove(models.QueueKindDownload, id)
+
+	// The status is set before the context is cancelled, so RunQueued either
+	// sees it or gets a cancelled context. A download that isn't running,
+	// such as a queued or paused one, is cleaned up here.
+	s.markDownloadCancelled(download)
+	if !s.contexts.cancel(id) {
+		s.removePartialFiles(download)
+	}
+
+	log.Printf("Download %s: Cancel requested", id)
 	return nil
 }
 
-func (s *DirectDownloadService) markDownloadFailed(download *models.DirectDownload, errorMsg string) {
+// PauseDownload stops a queued or running download and frees its download
+// slot. The partial file and its offset are kept, so ResumeDownload
+// continues from there, even after a restart.
+func (s *DirectDownloadService) PauseDownload(id string) error {
+	download, err := s.loadDownload(id)
+	if err != nil {
+		return ErrJobNotFound
+	}
+
 	s.mu.Lock()
-	download.Status = "failed"
-	download.Error = &errorMsg
+	if !pausable(download.Status) {
+		status := download.Status
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobNotPausable, status)
+	}
+	download.Status = StatusPaused
+	download.TransferRate = 0
+	download.ETASeconds = 0
 	download.UpdatedAt = time.Now()
+	err = database.SaveDirectDownload(download)
 	s.mu.Unlock()
 
-	if err := database.SaveDirectDownload(download); err != nil {
+	if err != nil {
 		log.Printf("Failed to update download in database: %v", err)
 	}
+
+	s.queue.Remove(models.QueueKindDownload, id)
+	s.contexts.cancel(id)
+
+	log.Printf("Download %s: Pause requested", id)
+	return nil
+}
+
+// ResumeDownload queues a paused download again. Provider URLs are resolved
+// again for the same stream, and the download continues from the saved
+// offset.
+func (s *DirectDownloadService) ResumeDownload(id string) error {
+	download, err := s.loadDownload(id)
+	if err != nil {
+		return ErrJobNotFound
+	}
+
+	if status := s.downloadStatus(download); status != StatusPaused {
+		return fmt.Errorf("%w: %s", ErrJobNotPaused, status)
+	}
+	if s.contexts.running(id) {
+		return ErrJobStopping
+	}
+
+	s.mu.Lock()
+	if download.Itag != 0 {
+		download.Quality = models.QualitySelection{Itag: download.Itag}
+	}
+	s.mu.Unlock()
+
+	s.enqueue(download)
+
+	log.Printf("Download %s: Resumed", id)
+	return nil
+}
+
+func (s *DirectDownloadService) downloadStatus(download *models.DirectDownload) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return download.Status
+}
+
+// setStatus moves a download on to its next step unless it was paused or
+// cancelled in the meantime.
+func (s *DirectDownloadService) setStatus(download *models.DirectDownload, status string) {
+	s.mu.Lock()
+	if stopped(download.Status) {
+		s.mu.Unlock()
+		return
+	}
+	download.Status = status
+	download.UpdatedAt = time.Now()
+	database.SaveDirectDownload(download)
+	s.mu.Unlock()
+}
+
+// removePartialFiles deletes whatever a download that won't finish left in
+// the temporary directory.
+func (s *DirectDownloadService) removePartialFiles(download *models.DirectDownload) {
+	s.mu.RLock()
+	filename := download.Filename
+	s.mu.RUnlock()
+
+	// The filename is only known once the download has been resolved
+	if filename == "" {
+		return
+	}
+
+	tempFile := filepath.Join(s.tempDir, filename)
+	for _, path := range []string{tempFile, tempFile + ".video", tempFile + ".audio"} {
+		os.Remove(path)
+	}
+}
+
+// GetAllDownloads lists every download, newest first, with its status and
+// progress. Downloads this process is running report their live progress.
+func (s *DirectDownloadService) GetAllDownloads() []map[string]interface{} {
+	downloads, err := database.LoadDirectDownloads()
+	if err != nil {
+		log.Printf("Error loading downloads from database: %v", err)
+		// Fall back to in-memory data if DB fetch fails
+		s.mu.RLock()
+		downloads = make([]models.DirectDownload, 0, len(s.downloads))
+		for _, download := range s.downloads {
+			downloads = append(downloads, *download)
+		}
+		s.mu.RUnlock()
+		sort.Slice(downloads, func(i, j int) bool {
+			return downloads[i].DownloadTime.After(downloads[j].DownloadTime)
+		})
+		return s.buildDownloadResponse(downloads)
+	}
+
+	s.mu.RLock()
+	for i := range downloads {
+		if live, exists := s.downloads[downloads[i].ID]; exists {
+			downloads[i] = *live
+		}
+	}
+	s.mu.RUnlock()
+
+	return s.buildDownloadResponse(downloads)
+}
+
+// DownloadStatus reports one download's status and progress like
+// GetAllDownloads does.
+func (s *DirectDownloadService) DownloadStatus(id string) (map[string]interface{}, error) {
+	download, err := s.loadDownload(id)
+	if err != nil {
+		return nil, ErrJobNotFound
+	}
+
+	s.mu.RLock()
+	snapshot := *download
+	s.mu.RUnlock()
+
+	return s.buildDownloadResponse([]models.DirectDownload{snapshot})[0], nil
+}
+
+func (s *DirectDownloadService) buildDownloadResponse(downloads []models.DirectDownload) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(downloads))
+	for _, download := range downloads {
+		var errorMsg string
+		if download.Error != nil {
+			errorMsg = *download.Error
+		}
+		var batchID string
+		if download.BatchID != nil {
+			batchID = *download.BatchID
+		}
+		result = append(result, map[string]interface{}{
+			"id":              download.ID,
+			"url":             download.URL,
+			"filename":        download.Filename,
+			"status":          download.Status,
+			"error":           errorMsg,
+			"errorCode":       download.ErrorCode,
+			"errorMessage":    ErrorMessage(download.ErrorCode),
+			"fileState":       download.FileState,
+			"itag":            download.Itag,
+			"batchId":         batchID,
+			"provider":        download.Provider,
+			"servedFrom":      download.ServedFrom,
+			"audioServedFrom": download.AudioServedFrom,
+			"downloadTime":    download.DownloadTime,
+			"progress":        download.Progress,
+			"bytesDone":       download.BytesDone,
+			"bytesTotal":      download.BytesTotal,
+			"bytesPerSecond":  download.TransferRate,
+			"etaSeconds":      download.ETASeconds,
+			"sha256":          download.SHA256,
+			"queuePosition":   s.queue.Position(models.QueueKindDownload, download.ID),
+		})
+	}
+	return result
 }
 
 func (s *DirectDownloadService) DeleteFile(id string) error {
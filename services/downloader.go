@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ServedFromPrimary = "primary"
+	ServedFromMirror  = "mirror"
+)
+
+// checkpointInterval is how often a resumable download reports its offset
+// for persisting.
+const checkpointInterval = 5 * time.Second
+
+// downloadTask describes one file to fetch. Offset and ETag describe a
+// partial file left by an earlier attempt, possibly before a restart.
+// Checkpoint, when set, is called as the download advances so they can be
+// persisted.
+type downloadTask struct {
+	URLs       []string
+	OutputPath string
+	LogPrefix  string
+	Offset     int64
+	ETag       string
+	Checkpoint func(offset int64, etag string)
+	// Segments is how many parallel ranges a fresh download is split into.
+	// 0 or 1 means a single stream.
+	Segments int
+	Progress *transferProgress
+	Throttle *throttle
+	// ExpectedSize is the size the provider announced, or 0 when unknown.
+	ExpectedSize int64
+	// Client fetches the URLs; http.DefaultClient when nil.
+	Client *http.Client
+	// MaxSize, when set, is the largest file that is accepted. Downloads
+	// going over it fail.
+	MaxSize int64
+
+	// unconfirmed is set when Offset was reached on another URL, so a
+	// resume has to show it is the same file before it is trusted.
+	unconfirmed bool
+}
+
+// sameFile reports whether a resumed response with the given ETag and
+// total size is the file Offset was reached on.
+func (t *downloadTask) sameFile(etag string, total int64) bool {
+	if !t.unconfirmed {
+		return t.ETag == "" || etag == "" || etag == t.ETag
+	}
+	if t.ETag != "" && etag != "" {
+		return etag == t.ETag
+	}
+	return t.ExpectedSize > 0 && total == t.ExpectedSize
+}
+
+// restart drops the partial file's offset so the next request starts over.
+func (t *downloadTask) restart() {
+	t.Offset, t.ETag = 0, ""
+	t.unconfirmed = false
+	t.checkpoint()
+}
+
+func (t *downloadTask) client() *http.Client {
+	if t.Client != nil {
+		return t.Client
+	}
+	return http.DefaultClient
+}
+
+// errTooLarge is returned once a file goes over task.MaxSize.
+func (t *downloadTask) errTooLarge() error {
+	return fmt.Errorf("file is over the %d byte limit", t.MaxSize)
+}
+
+// mirrorURLs lists the URLs a stream can be fetched from, primary first.
+func mirrorURLs(primary, mirror string) []string {
+	if mirror == "" || mirror == primary {
+		return []string{primary}
+	}
+	return []string{primary, mirror}
+}
+
+// servedFrom names the mirror at index i of a mirrorURLs list.
+func servedFrom(i int) string {
+	if i == 0 {
+		return ServedFromPrimary
+	}
+	return ServedFromMirror
+}
+
+// fetchWithMirrors saves the first URL that downloads cleanly to
+// task.OutputPath and returns its index. Failures are retried a few times
+// before giving up on a URL; a bad status or a file that doesn't match its
+// advertised or expected size moves straight on to the next one. Partial
+// files are kept so a later attempt can resume them. Cancelling ctx stops
+// the download with ctx's error.
+func fetchWithMirrors(ctx context.Context, task *downloadTask) (int, error) {
+	var lastErr error
+	for i, downloadURL := range task.URLs {
+		if i > 0 {
+			log.Printf("%s: Switching to %s mirror after: %v", task.LogPrefix, servedFrom(i), lastErr)
+			// Mirrors may serve the file differently, so the partial file
+			// is only kept if the mirror proves it is the same one
+			if task.Offset > 0 {
+				if task.ETag == "" && task.ExpectedSize <= 0 {
+					task.restart()
+				} else {
+					task.unconfirmed = true
+				}
+			}
+		}
+
+		err := fetchURL(ctx, task, downloadURL)
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if err == nil {
+			err = checkSize(task.OutputPath, task.ExpectedSize)
+			if err != nil {
+				// The file is wrong rather than unfinished, so don't resume it
+				os.Remove(task.OutputPath)
+				task.restart()
+			}
+		}
+		if err == nil {
+			task.Progress.finishFile()
+			log.Printf("%s: Downloaded %d bytes from %s", task.LogPrefix, task.Offset, servedFrom(i))
+			return i, nil
+		}
+		lastErr = err
+	}
+	return 0, lastErr
+}
+
+// DownloadFile saves the first of urls that downloads cleanly to
+// outputPath, resuming from offset when a partial file with the given ETag
+// is already there. A fresh download is split into segments parallel
+// ranges when the server allows it. It returns how much of the file is
+// saved, all of it unless there is an error, and its ETag.
+func DownloadFile(ctx context.Context, urls []string, outputPath string, segments int, offset int64, etag string) (int64, string, error) {
+	task := &downloadTask{
+		URLs:       urls,
+		OutputPath: outputPath,
+		LogPrefix:  "Download " + filepath.Base(outputPath),
+		Offset:     offset,
+		ETag:       etag,
+		Segments:   segments,
+	}
+	_, err := fetchWithMirrors(ctx, task)
+	return task.Offset, task.ETag, err
+}
+
+func fetchURL(ctx context.Context, task *downloadTask, downloadURL string) error {
+	// A partial single stream download is resumed rather than split up
+	if task.Segments > 1 && task.Offset == 0 {
+		if ok, err := fetchSegmented(ctx, task, downloadURL); ok {
+			return err
+		}
+	}
+
+	maxRetries := 3
+
+	var err error
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleepContext(ctx, time.Duration(5*attempt)*time.Second); err != nil {
+				return err
+			}
+		}
+
+		var retry bool
+		retry, err = fetchOnce(ctx, task, downloadURL)
+		if err == nil || !retry {
+			return err
+		}
+		log.Printf("%s: Download attempt %d failed at byte %d: %v", task.LogPrefix, attempt+1, task.Offset, err)
+	}
+	return err
+}
+
+// fetchOnce makes a single request, resuming from task.Offset when the
+// server supports ranges. retry is true for failures worth another attempt
+// at the same URL.
+func fetchOnce(ctx context.Context, task *downloadTask, downloadURL string) (bool, error) {
+	// Only trust an offset that is really on disk
+	if task.Offset > 0 {
+		if info, err := os.Stat(task.OutputPath); err != nil || info.Size() < task.Offset {
+			task.Offset = 0
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return false, err
+	}
+	if task.Offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", task.Offset))
+		// If-Range makes the server send the whole file when it changed
+		if task.ETag != "" {
+			req.Header.Set("If-Range", task.ETag)
+		}
+	}
+
+	resp, err := task.client().Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	resumable := false
+	total := int64(-1)
+	var out *os.File
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && task.Offset > 0:
+		contentRange := resp.Header.Get("Content-Range")
+		total = contentRangeTotal(contentRange)
+		if contentRangeStart(contentRange) != task.Offset || !task.sameFile(resp.Header.Get("ETag"), total) {
+			task.restart()
+			return true, fmt.Errorf("server resumed at the wrong offset or a different file")
+		}
+		log.Printf("%s: Resuming at byte %d", task.LogPrefix, task.Offset)
+		task.unconfirmed = false
+		resumable = true
+		out, err = os.OpenFile(task.OutputPath, os.O_WRONLY, 0644)
+		if err == nil {
+			err = out.Truncate(task.Offset)
+		}
+		if err == nil {
+			_, err = out.Seek(task.Offset, io.SeekStart)
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && task.Offset > 0 &&
+		contentRangeTotal(resp.Header.Get("Content-Range")) == task.Offset &&
+		task.sameFile(resp.Header.Get("ETag"), task.Offset):
+		// The partial file is already complete
+		task.unconfirmed = false
+		task.Progress.startFile(task.Offset, task.Offset)
+		return false, nil
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && task.Offset > 0:
+		// The partial file is longer than the file or belongs to another one
+		offset := task.Offset
+		task.restart()
+		return true, fmt.Errorf("server can't resume at byte %d", offset)
+	case resp.StatusCode == http.StatusOK:
+		task.Offset = 0
+		task.ETag = resp.Header.Get("ETag")
+		task.unconfirmed = false
+		resumable = resp.Header.Get("Accept-Ranges") == "bytes"
+		total = resp.ContentLength
+		out, err = os.Create(task.OutputPath)
+	default:
+		return false, fmt.Errorf("download failed with status code: %d", resp.StatusCode)
+	}
+	if err != nil {
+		if out != nil {
+			out.Close()
+		}
+		return false, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer out.Close()
+
+	if task.MaxSize > 0 && total > task.MaxSize {
+		os.Remove(task.OutputPath)
+		task.restart()
+		return false, task.errTooLarge()
+	}
+
+	task.Progress.startFile(task.Offset, total)
+	var limited io.Reader = resp.Body
+	if task.MaxSize > 0 {
+		// One byte past the limit is enough to tell the file is too big
+		limited = io.LimitReader(resp.Body, task.MaxSize-task.Offset+1)
+	}
+	body := &countingReader{r: &throttledReader{ctx: ctx, r: limited, throttle: task.Throttle}, progress: task.Progress}
+
+	if err := task.copyFrom(out, body, resumable); err != nil {
+		// Without range support the next attempt has to start over
+		if !resumable {
+			task.Offset = 0
+			task.ETag = ""
+		}
+		task.checkpoint()
+		return true, fmt.Errorf("failed to save video: %w", err)
+	}
+	if task.MaxSize > 0 && task.Offset > task.MaxSize {
+		out.Close()
+		os.Remove(task.OutputPath)
+		task.restart()
+		return false, task.errTooLarge()
+	}
+	task.checkpoint()
+
+	if total >= 0 && task.Offset != total {
+		return false, fmt.Errorf("%w: got %d of %d bytes", ErrCorruptFile, task.Offset, total)
+	}
+	return false, nil
+}
+
+// copyFrom appends body to out, advancing Offset as it goes and
+// checkpointing resumable downloads every checkpointInterval.
+func (t *downloadTask) copyFrom(out io.Writer, body io.Reader, resumable bool) error {
+	buf := make([]byte, 32*1024)
+	lastCheckpoint := time.Now()
+
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := out.Write(buf[:n]); err != nil {
+				return err
+			}
+			t.Offset += int64(n)
+
+			if resumable && time.Since(lastCheckpoint) >= checkpointInterval {
+				t.checkpoint()
+				lastCheckpoint = time.Now()
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+func (t *downloadTask) checkpoint() {
+	if t.Checkpoint != nil {
+		t.Checkpoint(t.Offset, t.ETag)
+	}
+}
+
+// contentRangeStart reads the first byte from a "bytes 100-199/1234"
+// header.
+func contentRangeStart(contentRange string) int64 {
+	rangeSpec := strings.TrimPrefix(contentRange, "bytes ")
+	i := strings.Index(rangeSpec, "-")
+	if i < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(rangeSpec[:i], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error codes stored on failed jobs and returned by the API, so clients can
+// explain a failure and decide whether retrying makes sense.
+const (
+	ErrorCodeInvalidID         = "invalid_id"
+	ErrorCodeUnavailable       = "unavailable"
+	ErrorCodeAgeRestricted     = "age_restricted"
+	ErrorCodeQuotaExhausted    = "quota_exhausted"
+	ErrorCodeUpstream          = "upstream_error"
+	ErrorCodeMalformedResponse = "malformed_response"
+	ErrorCodeCorruptFile       = "corrupt_file"
+	ErrorCodeInterrupted       = "interrupted"
+)
+
+var (
+	ErrInvalidVideoID    = errors.New("invalid video ID")
+	ErrVideoUnavailable  = errors.New("video is private or has been removed")
+	ErrAgeRestricted     = errors.New("video is age-restricted")
+	ErrUpstream          = errors.New("provider error")
+	ErrMalformedResponse = errors.New("malformed provider response")
+	ErrCorruptFile       = errors.New("file failed integrity checks")
+	ErrInterrupted       = errors.New("interrupted by a restart")
+)
+
+// errorCodes is checked in order, so when a resolver chain joins several
+// provider errors the most useful code wins.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrInvalidVideoID, ErrorCodeInvalidID},
+	{ErrVideoUnavailable, ErrorCodeUnavailable},
+	{ErrAgeRestricted, ErrorCodeAgeRestricted},
+	{ErrQuotaExceeded, ErrorCodeQuotaExhausted},
+	{ErrUpstream, ErrorCodeUpstream},
+	{ErrMalformedResponse, ErrorCodeMalformedResponse},
+	{ErrCorruptFile, ErrorCodeCorruptFile},
+	{ErrInterrupted, ErrorCodeInterrupted},
+}
+
+// ErrorCode returns the code for a typed provider error, or "" when err
+// isn't one.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return ""
+}
+
+// Retryable reports whether a failure with this code may succeed on a later
+// attempt. Problems with the video itself won't go away by retrying.
+func Retryable(code string) bool {
+	switch code {
+	case ErrorCodeInvalidID, ErrorCodeUnavailable, ErrorCodeAgeRestricted:
+		return false
+	}
+	return true
+}
+
+// ErrorMessage is a short explanation of a code for people.
+func ErrorMessage(code string) string {
+	switch code {
+	case ErrorCodeInvalidID:
+		return "The link doesn't point to a valid video"
+	case ErrorCodeUnavailable:
+		return "The video is private or has been removed"
+	case ErrorCodeAgeRestricted:
+		return "The video is age-restricted and can't be downloaded"
+	case ErrorCodeQuotaExhausted:
+		return "The provider quota is used up, try again later"
+	case ErrorCodeUpstream:
+		return "The provider is having problems, try again later"
+	case ErrorCodeMalformedResponse:
+		return "The provider sent a response we couldn't read"
+	case ErrorCodeCorruptFile:
+		return "The file was incomplete or damaged, try again"
+	case ErrorCodeInterrupted:
+		return "The server stopped while the job was running, try again"
+	}
+	return ""
+}
+
+// statusError turns an unexpected HTTP status from a provider into a typed
+// error.
+func statusError(provider string, status int) error {
+	switch {
+	case status == http.StatusBadRequest:
+		return fmt.Errorf("%w: %s returned status %d", ErrInvalidVideoID, provider, status)
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return fmt.Errorf("%w: %s returned status %d", ErrVideoUnavailable, provider, status)
+	case status == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s returned status %d", ErrQuotaExceeded, provider, status)
+	default:
+		return fmt.Errorf("%w: %s returned status %d", ErrUpstream, provider, status)
+	}
+}
+
+// classifyMessage recognises the reasons providers give for refusing a
+// video. It returns nil when the message doesn't say.
+func classifyMessage(message string) error {
+	message = strings.ToLower(message)
+	switch {
+	case strings.Contains(message, "age-restricted"), strings.Contains(message, "age restricted"),
+		strings.Contains(message, "confirm your age"), strings.Contains(message, "inappropriate"):
+		return fmt.Errorf("%w: %s", ErrAgeRestricted, message)
+	case strings.Contains(message, "private"), strings.Contains(message, "unavailable"),
+		strings.Contains(message, "removed"), strings.Contains(message, "terminated"),
+		strings.Contains(message, "not found"):
+		return fmt.Errorf("%w: %s", ErrVideoUnavailable, message)
+	}
+	return nil
+}
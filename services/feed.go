@@ -0,0 +1,50 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// atomFeed is the subset of YouTube's Atom feeds (channels and playlists)
+// that we use.
+type atomFeed struct {
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	VideoID   string    `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	ChannelID string    `xml:"http://www.youtube.com/xml/schemas/2015 channelId"`
+	Title     string    `xml:"title"`
+	Published time.Time `xml:"published"`
+	Author    struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+}
+
+func fetchFeed(feedURL string) (*atomFeed, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("invalid feed: %w", err)
+	}
+	return &feed, nil
+}
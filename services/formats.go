@@ -0,0 +1,191 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vicradon/yt-downloader/models"
+)
+
+// DefaultItag is the stream requested when the client doesn't ask for a
+// particular quality (720p WebM).
+const DefaultItag = 247
+
+// itagHeights maps well-known YouTube itags to their video height.
+var itagHeights = map[int]int{
+	// Muxed MP4
+	18: 360, 22: 720,
+	// MP4 (H.264)
+	160: 144, 133: 240, 134: 360, 135: 480, 136: 720, 137: 1080, 264: 1440, 266: 2160,
+	298: 720, 299: 1080,
+	// WebM (VP9)
+	278: 144, 242: 240, 243: 360, 244: 480, 247: 720, 248: 1080, 271: 1440, 313: 2160,
+	302: 720, 303: 1080, 308: 1440, 315: 2160,
+	// MP4 (AV1)
+	394: 144, 395: 240, 396: 360, 397: 480, 398: 720, 399: 1080, 400: 1440, 401: 2160,
+}
+
+// Itags that carry both audio and video in one file.
+var muxedItags = map[int]bool{18: true, 22: true}
+
+// Video-only itags delivered as WebM.
+var webmItags = map[int]bool{
+	278: true, 242: true, 243: true, 244: true, 247: true, 248: true,
+	271: true, 302: true, 303: true, 308: true, 313: true, 315: true,
+}
+
+// Audio-only itags, keyed to their container.
+var audioItags = map[int]string{
+	139: "mp4", 140: "mp4", 141: "mp4",
+	249: "webm", 250: "webm", 251: "webm",
+}
+
+func itagHeight(itag int) int {
+	return itagHeights[itag]
+}
+
+func isAudioItag(itag int) bool {
+	_, ok := audioItags[itag]
+	return ok
+}
+
+const (
+	StreamMuxed     = "muxed"
+	StreamVideoOnly = "video"
+	StreamAudioOnly = "audio"
+)
+
+// StreamKind reports whether a stream has audio, video or both. The mime
+// type is authoritative; the itag tables are used when it is missing.
+func StreamKind(stream *models.MediaStream) string {
+	if strings.HasPrefix(stream.Mime, "audio/") {
+		return StreamAudioOnly
+	}
+	if strings.HasPrefix(stream.Mime, "video/") {
+		if muxedItags[stream.Itag] || strings.Contains(stream.Mime, ",") {
+			return StreamMuxed
+		}
+		return StreamVideoOnly
+	}
+
+	switch {
+	case isAudioItag(stream.Itag):
+		return StreamAudioOnly
+	case itagHeight(stream.Itag) > 0 && !muxedItags[stream.Itag]:
+		return StreamVideoOnly
+	default:
+		return StreamMuxed
+	}
+}
+
+// matchingAudioItag picks an audio stream whose container can be muxed with
+// the given video stream without re-encoding.
+func matchingAudioItag(video *models.MediaStream) int {
+	container, _ := parseMime(video.Mime)
+	if container == "" {
+		container = streamExtension(video)
+	}
+	if container == "webm" {
+		return 251
+	}
+	return 140
+}
+
+// streamExtension returns the file extension to use when saving a stream
+// as-is.
+func streamExtension(stream *models.MediaStream) string {
+	container, _ := parseMime(stream.Mime)
+	if container == "" {
+		if c, ok := audioItags[stream.Itag]; ok {
+			container = c
+		} else if webmItags[stream.Itag] {
+			container = "webm"
+		}
+	}
+
+	switch {
+	case container == "":
+		return "mp4"
+	case container == "mp4" && StreamKind(stream) == StreamAudioOnly:
+		return "m4a"
+	default:
+		return container
+	}
+}
+
+// parseMime splits a mime type such as `video/webm; codecs="vp9"` into its
+// container ("webm") and codec ("vp9").
+func parseMime(mime string) (string, string) {
+	parts := strings.SplitN(mime, ";", 2)
+
+	container := ""
+	if slash := strings.Index(parts[0], "/"); slash >= 0 {
+		container = strings.TrimSpace(parts[0][slash+1:])
+	}
+
+	codec := ""
+	if len(parts) > 1 {
+		params := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(params, "codecs=") {
+			codec = strings.Trim(strings.TrimPrefix(params, "codecs="), `"`)
+			codec = strings.TrimSpace(strings.Split(codec, ",")[0])
+		}
+	}
+
+	return container, codec
+}
+
+// SelectFormat picks the best video stream that satisfies the selection:
+// the tallest stream not exceeding MaxHeight, preferring higher bitrates.
+func SelectFormat(formats []models.MediaStream, quality models.QualitySelection) (*models.MediaStream, error) {
+	var best *models.MediaStream
+
+	for i := range formats {
+		format := &formats[i]
+		container, codec := parseMime(format.Mime)
+
+		if !strings.HasPrefix(format.Mime, "video/") {
+			continue
+		}
+		if quality.MaxHeight > 0 && format.Height > quality.MaxHeight {
+			continue
+		}
+		if quality.Container != "" && !strings.EqualFold(container, quality.Container) {
+			continue
+		}
+		if quality.Codec != "" && !strings.HasPrefix(strings.ToLower(codec), strings.ToLower(quality.Codec)) {
+			continue
+		}
+
+		if best == nil || format.Height > best.Height ||
+			(format.Height == best.Height && format.Bitrate > best.Bitrate) {
+			best = format
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no stream matches the requested quality")
+	}
+	return best, nil
+}
+
+// resolveItag works out which itag to request from the source.
+func resolveItag(source VideoSource, videoID string, quality models.QualitySelection) (int, error) {
+	if quality.Itag != 0 {
+		return quality.Itag, nil
+	}
+	if quality.IsZero() {
+		return DefaultItag, nil
+	}
+
+	formats, err := source.Formats(videoID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list formats: %w", err)
+	}
+
+	format, err := SelectFormat(formats, quality)
+	if err != nil {
+		return 0, err
+	}
+	return format.Itag, nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vicradon/yt-downloader/utils"
+)
+
+// checkSize compares a finished download with the size the provider
+// announced. want <= 0 means the size isn't known.
+func checkSize(path string, want int64) error {
+	if want <= 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() != want {
+		return fmt.Errorf("%w: got %d bytes, provider announced %d", ErrCorruptFile, info.Size(), want)
+	}
+	return nil
+}
+
+// checkPlayable has ffprobe read the container and fails when it reports
+// errors, finds no audio or video, or can't tell the duration. The container
+// can look fine while its data is cut short, so the start and the end of the
+// file are also decoded.
+func checkPlayable(path string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := utils.BuildProbeCommand(path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: ffprobe failed: %v: %s", ErrCorruptFile, err, lastLine(stderr.Bytes()))
+	}
+	if stderr.Len() > 0 {
+		return fmt.Errorf("%w: %s", ErrCorruptFile, lastLine(stderr.Bytes()))
+	}
+
+	hasMedia := false
+	duration := 0.0
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "codec_type":
+			if value == "video" || value == "audio" {
+				hasMedia = true
+			}
+		case "duration":
+			duration, _ = strconv.ParseFloat(value, 64)
+		}
+	}
+
+	if !hasMedia {
+		return fmt.Errorf("%w: no audio or video streams", ErrCorruptFile)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("%w: unknown duration", ErrCorruptFile)
+	}
+
+	for _, fromEnd := range []bool{false, true} {
+		if err := checkDecodes(path, fromEnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDecodes fails when ffmpeg prints anything while decoding a few
+// seconds of path.
+func checkDecodes(path string, fromEnd bool) error {
+	var stderr bytes.Buffer
+	cmd := utils.BuildDecodeCheckCommand(path, fromEnd)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: ffmpeg failed to decode: %v: %s", ErrCorruptFile, err, lastLine(stderr.Bytes()))
+	}
+	if stderr.Len() > 0 {
+		return fmt.Errorf("%w: %s", ErrCorruptFile, lastLine(stderr.Bytes()))
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// PublishFile moves a finished file from its temporary path to finalPath once
+// it passes checkPlayable, so nothing half-written is ever served. It returns
+// the file's SHA-256. The temporary file is removed when a check fails.
+func PublishFile(tempPath, finalPath string) (string, error) {
+	if err := checkPlayable(tempPath); err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+
+	sum, err := fileSHA256(tempPath)
+	if err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, finalPath); err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to move file: %w", err)
+	}
+	return sum, nil
+}
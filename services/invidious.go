@@ -0,0 +1,225 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vicradon/yt-downloader/models"
+)
+
+// InvidiousSource resolves YouTube videos through an Invidious instance's
+// API. It is used as a fallback when RapidAPI is unavailable.
+type InvidiousSource struct {
+	Host string
+}
+
+func NewInvidiousSource(host string) *InvidiousSource {
+	return &InvidiousSource{
+		Host: strings.TrimSuffix(host, "/"),
+	}
+}
+
+type invidiousFormat struct {
+	URL        string `json:"url"`
+	Itag       string `json:"itag"`
+	Type       string `json:"type"`
+	Bitrate    string `json:"bitrate"`
+	Clen       string `json:"clen"`
+	Resolution string `json:"resolution"`
+}
+
+type invidiousVideo struct {
+	Title           string            `json:"title"`
+	Author          string            `json:"author"`
+	AdaptiveFormats []invidiousFormat `json:"adaptiveFormats"`
+	FormatStreams   []invidiousFormat `json:"formatStreams"`
+}
+
+// invidiousPlaylist is one page of a playlist. Invidious returns the videos
+// of a playlist a page at a time, and pages past the end have no videos.
+type invidiousPlaylist struct {
+	Title      string `json:"title"`
+	VideoCount int    `json:"videoCount"`
+	Videos     []struct {
+		Title   string `json:"title"`
+		VideoID string `json:"videoId"`
+		Author  string `json:"author"`
+	} `json:"videos"`
+}
+
+func (s *InvidiousSource) Name() string {
+	return "invidious"
+}
+
+func (s *InvidiousSource) ExtractVideoID(url string) (string, error) {
+	parsed, err := ParseYouTubeURL(url)
+	if err != nil {
+		return "", err
+	}
+	if parsed.VideoID == "" {
+		return "", fmt.Errorf("could not extract video ID from URL")
+	}
+	return parsed.VideoID, nil
+}
+
+func (s *InvidiousSource) getVideo(videoID string) (*invidiousVideo, error) {
+	body, err := s.get(fmt.Sprintf("%s/api/v1/videos/%s?fields=title,author,adaptiveFormats,formatStreams", s.Host, videoID))
+	if err != nil {
+		return nil, err
+	}
+
+	var video invidiousVideo
+	if err := json.Unmarshal(body, &video); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+	return &video, nil
+}
+
+func (s *InvidiousSource) get(apiURL string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// Invidious explains refusals in an error field, even on 500s
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil {
+			if err := classifyMessage(apiErr.Error); err != nil {
+				return nil, err
+			}
+		}
+		return nil, statusError("invidious", resp.StatusCode)
+	}
+	return body, nil
+}
+
+func (s *InvidiousSource) ExtractPlaylistID(url string) (string, bool) {
+	return extractPlaylistID(url)
+}
+
+// PlaylistVideos pages through a playlist until a page brings no new videos
+// or the playlist's video count is reached, so it isn't limited to the few
+// entries of the Atom feed.
+func (s *InvidiousSource) PlaylistVideos(playlistID string) (string, []models.VideoMetadata, error) {
+	var title string
+	var videos []models.VideoMetadata
+	seen := make(map[string]bool)
+
+	for page := 1; ; page++ {
+		body, err := s.get(fmt.Sprintf("%s/api/v1/playlists/%s?page=%d", s.Host, url.PathEscape(playlistID), page))
+		if err != nil {
+			return "", nil, err
+		}
+
+		var playlist invidiousPlaylist
+		if err := json.Unmarshal(body, &playlist); err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+		}
+		title = playlist.Title
+
+		added := 0
+		for _, video := range playlist.Videos {
+			// Pages can overlap, and private or deleted entries have no ID
+			if video.VideoID == "" || seen[video.VideoID] {
+				continue
+			}
+			seen[video.VideoID] = true
+			videos = append(videos, models.VideoMetadata{
+				ID:     video.VideoID,
+				Title:  video.Title,
+				Author: video.Author,
+			})
+			added++
+		}
+
+		if added == 0 || (playlist.VideoCount > 0 && len(videos) >= playlist.VideoCount) {
+			break
+		}
+	}
+
+	if len(videos) == 0 {
+		return "", nil, fmt.Errorf("playlist %s has no videos", playlistID)
+	}
+	return title, videos, nil
+}
+
+func (f invidiousFormat) toMediaStream(title string) models.MediaStream {
+	itag, _ := strconv.Atoi(f.Itag)
+	size, _ := strconv.ParseInt(f.Clen, 10, 64)
+	bitrate, _ := strconv.ParseInt(f.Bitrate, 10, 64)
+
+	height := itagHeight(itag)
+	if height == 0 {
+		height, _ = strconv.Atoi(strings.TrimSuffix(f.Resolution, "p"))
+	}
+
+	streamType := "video"
+	if strings.HasPrefix(f.Type, "audio/") {
+		streamType = "audio"
+	}
+
+	return models.MediaStream{
+		Itag:     itag,
+		Height:   height,
+		Type:     streamType,
+		Mime:     f.Type,
+		Size:     size,
+		Bitrate:  bitrate,
+		File:     f.URL,
+		Title:    title,
+		Provider: "invidious",
+	}
+}
+
+func (s *InvidiousSource) Resolve(videoID string, itag int) (*models.MediaStream, error) {
+	video, err := s.getVideo(videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, format := range append(video.AdaptiveFormats, video.FormatStreams...) {
+		if format.Itag == strconv.Itoa(itag) && format.URL != "" {
+			stream := format.toMediaStream(video.Title)
+			return &stream, nil
+		}
+	}
+	return nil, fmt.Errorf("no download URL for itag %d", itag)
+}
+
+func (s *InvidiousSource) Metadata(videoID string) (*models.VideoMetadata, error) {
+	video, err := s.getVideo(videoID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.VideoMetadata{ID: videoID, Title: video.Title, Author: video.Author}, nil
+}
+
+func (s *InvidiousSource) Formats(videoID string) ([]models.MediaStream, error) {
+	video, err := s.getVideo(videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	all := append(video.AdaptiveFormats, video.FormatStreams...)
+	formats := make([]models.MediaStream, 0, len(all))
+	for _, format := range all {
+		formats = append(formats, format.toMediaStream(""))
+	}
+	return formats, nil
+}
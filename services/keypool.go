@@ -0,0 +1,174 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vicradon/yt-downloader/models"
+)
+
+const (
+	KeyStrategyRoundRobin = "round_robin"
+	KeyStrategyLeastUsed  = "least_used"
+)
+
+// APIKey is one RapidAPI key together with the host it is subscribed to.
+type APIKey struct {
+	Key  string
+	Host string
+
+	quota         *QuotaTracker
+	cooldownUntil time.Time
+}
+
+// KeyPool rotates requests across several RapidAPI keys. Keys that get
+// rejected (401/403/429) are benched for a cooldown period.
+type KeyPool struct {
+	keys     []*APIKey
+	mu       sync.Mutex
+	strategy string
+	cooldown time.Duration
+	next     int
+}
+
+// NewKeyPool pairs keys with hosts by position. A single host is shared by
+// all keys.
+func NewKeyPool(keys, hosts []string, strategy string, cooldown time.Duration) *KeyPool {
+	pool := &KeyPool{
+		strategy: strategy,
+		cooldown: cooldown,
+	}
+
+	for i, key := range keys {
+		host := ""
+		switch {
+		case i < len(hosts):
+			host = hosts[i]
+		case len(hosts) > 0:
+			host = hosts[len(hosts)-1]
+		}
+		pool.keys = append(pool.keys, &APIKey{
+			Key:   key,
+			Host:  host,
+			quota: NewQuotaTracker(key),
+		})
+	}
+
+	return pool
+}
+
+func (k *APIKey) available(now time.Time) bool {
+	return now.After(k.cooldownUntil) && !k.quota.Exhausted()
+}
+
+// Acquire picks the next usable key according to the pool strategy.
+func (p *KeyPool) Acquire() (*APIKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	if p.strategy == KeyStrategyLeastUsed {
+		var best *APIKey
+		bestUsed := 0
+		for _, key := range p.keys {
+			if !key.available(now) {
+				continue
+			}
+			used := key.quota.UsedToday()
+			if best == nil || used < bestUsed {
+				best, bestUsed = key, used
+			}
+		}
+		if best == nil {
+			return nil, fmt.Errorf("%w: no API key available", ErrQuotaExceeded)
+		}
+		return best, nil
+	}
+
+	for i := 0; i < len(p.keys); i++ {
+		key := p.keys[(p.next+i)%len(p.keys)]
+		if key.available(now) {
+			p.next = (p.next + i + 1) % len(p.keys)
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no API key available", ErrQuotaExceeded)
+}
+
+// Cooldown takes a key out of rotation for the pool's cooldown period.
+func (p *KeyPool) Cooldown(key *APIKey, reason string) {
+	p.mu.Lock()
+	key.cooldownUntil = time.Now().Add(p.cooldown)
+	p.mu.Unlock()
+
+	log.Printf("RapidAPI key %s benched for %s: %s", key.quota.keyID, p.cooldown, reason)
+}
+
+// MarkRateLimited benches a key the provider answered 429 for. Its quota
+// counts as spent until the provider's reset time or, when that is unknown,
+// the end of the cooldown.
+func (p *KeyPool) MarkRateLimited(key *APIKey) {
+	key.quota.MarkExhausted(p.cooldown)
+	p.Cooldown(key, "rate limited")
+}
+
+func (p *KeyPool) Size() int {
+	return len(p.keys)
+}
+
+// Exhausted reports whether every key is cooling down or out of quota.
+func (p *KeyPool) Exhausted() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range p.keys {
+		if key.available(now) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *KeyPool) UsedToday() int {
+	total := 0
+	for _, key := range p.keys {
+		total += key.quota.UsedToday()
+	}
+	return total
+}
+
+// Status sums the quota of all keys and lists each key separately.
+func (p *KeyPool) Status() models.QuotaStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var total models.QuotaStatus
+	now := time.Now()
+
+	for _, key := range p.keys {
+		status := key.quota.Status()
+		status.KeyID = key.quota.keyID
+		status.Host = key.Host
+		status.Exhausted = !key.available(now)
+		if now.Before(key.cooldownUntil) {
+			cooldownUntil := key.cooldownUntil
+			status.CooldownUntil = &cooldownUntil
+		}
+
+		total.Limit += status.Limit
+		if status.Remaining > 0 {
+			total.Remaining += status.Remaining
+		}
+		total.UsedToday += status.UsedToday
+		if status.ResetAt != nil && (total.ResetAt == nil || status.ResetAt.Before(*total.ResetAt)) {
+			total.ResetAt = status.ResetAt
+		}
+		total.Keys = append(total.Keys, status)
+	}
+
+	return total
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// MediaURLProvider is the provider recorded on jobs that fetch a plain
+// HTTP(S) link instead of going through a video source.
+const MediaURLProvider = "http"
+
+// MediaProbe is what a HEAD request tells us about a media link.
+type MediaProbe struct {
+	ID          string
+	URL         string
+	ContentType string
+	Size        int64
+	Filename    string
+}
+
+// MediaURLService accepts direct links to media files (CDNs, presigned S3
+// URLs, ...). Hosts are checked against an allowlist and denylist and files
+// over MaxSize are refused, so the server can't be used as an open proxy.
+type MediaURLService struct {
+	allowlist []string
+	denylist  []string
+	MaxSize   int64
+	client    *http.Client
+}
+
+func NewMediaURLService(allowlist, denylist []string, maxSize int64) *MediaURLService {
+	s := &MediaURLService{
+		allowlist: allowlist,
+		denylist:  denylist,
+		MaxSize:   maxSize,
+	}
+	s.client = s.newClient()
+	return s
+}
+
+// Client returns the HTTP client media links must be fetched with. The
+// address is checked again on every connection, and the host on every
+// redirect, since either may differ from what Probe saw.
+func (s *MediaURLService) Client() *http.Client {
+	return s.client
+}
+
+func (s *MediaURLService) newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 15 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+				return fmt.Errorf("refusing to connect to private address %s", host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			// A proxy would be dialled instead of the media host, so the
+			// address check above would be moot
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   15 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("refusing to follow redirect to %s", req.URL.Scheme)
+			}
+			return s.checkHost(req.URL.Hostname())
+		},
+	}
+}
+
+// Accepts reports whether rawURL is an HTTP(S) link that isn't YouTube, so
+// it should be probed rather than handed to a video source.
+func (s *MediaURLService) Accepts(rawURL string) bool {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	_, err = ParseYouTubeURL(rawURL)
+	return err != nil
+}
+
+// Probe checks that the link is allowed and points at a media file within
+// the size cap.
+func (s *MediaURLService) Probe(rawURL string) (*MediaProbe, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidVideoID, err)
+	}
+	if err := s.checkHost(u.Hostname()); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.probeMedia(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, statusError(u.Host, resp.StatusCode)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !isMediaType(contentType) {
+		return nil, fmt.Errorf("%w: %s is not a media file", ErrInvalidVideoID, contentType)
+	}
+
+	size := resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		size = contentRangeTotal(resp.Header.Get("Content-Range"))
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("%w: server did not report the file size", ErrMalformedResponse)
+	}
+	if s.MaxSize > 0 && size > s.MaxSize {
+		return nil, fmt.Errorf("file is %d bytes, over the %d byte limit", size, s.MaxSize)
+	}
+
+	sum := sha256.Sum256([]byte(rawURL))
+	return &MediaProbe{
+		ID:          "url_" + hex.EncodeToString(sum[:])[:12],
+		URL:         rawURL,
+		ContentType: contentType,
+		Size:        size,
+		Filename:    mediaFilename(u, resp.Header.Get("Content-Disposition"), contentType),
+	}, nil
+}
+
+// checkHost applies the lists and always refuses hosts on private networks.
+func (s *MediaURLService) checkHost(host string) error {
+	host = strings.ToLower(host)
+	if matchesHost(host, s.denylist) {
+		return fmt.Errorf("host %s is not allowed", host)
+	}
+	if len(s.allowlist) > 0 && !matchesHost(host, s.allowlist) {
+		return fmt.Errorf("host %s is not on the allowlist", host)
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	for _, ip := range ips {
+		if privateIP(ip) {
+			return fmt.Errorf("host %s resolves to a private address", host)
+		}
+	}
+	return nil
+}
+
+// restrict makes a download of a media link go through Client and stop at
+// MaxSize.
+func (s *MediaURLService) restrict(task *downloadTask) {
+	if s == nil {
+		return
+	}
+	task.Client = s.client
+	task.MaxSize = s.MaxSize
+}
+
+// privateIP reports whether ip is loopback, private, link-local or
+// unspecified, i.e. somewhere media links must never reach.
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// matchesHost reports whether host is one of the entries or a subdomain of
+// one.
+func matchesHost(host string, entries []string) bool {
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimPrefix(entry, "."))
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// probeMedia sends a HEAD request, falling back to a one byte range request
+// for servers that refuse HEAD (presigned S3 URLs are signed for GET only).
+func (s *MediaURLService) probeMedia(rawURL string) (*http.Response, error) {
+	client := *s.client
+	client.Timeout = 15 * time.Second
+
+	resp, err := client.Head(rawURL)
+	if err == nil && resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusMethodNotAllowed {
+		return resp, nil
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	return client.Do(req)
+}
+
+func isMediaType(contentType string) bool {
+	switch {
+	case strings.HasPrefix(contentType, "video/"), strings.HasPrefix(contentType, "audio/"):
+		return true
+	case contentType == "application/octet-stream", contentType == "binary/octet-stream",
+		contentType == "application/mp4":
+		return true
+	}
+	return false
+}
+
+// contentRangeTotal reads the total size from a "bytes 0-0/1234" header.
+func contentRangeTotal(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
+
+// mediaFilename prefers the name from Content-Disposition, then the last
+// path segment, adding an extension from the content type when missing.
+func mediaFilename(u *url.URL, disposition, contentType string) string {
+	var name string
+	if _, params, err := mime.ParseMediaType(disposition); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = path.Base(u.Path)
+		if name == "/" || name == "." {
+			name = ""
+		}
+	}
+	// Dot names like ".." would point out of the download directory, and a
+	// leading dot hides the file
+	name = strings.TrimLeft(sanitizeFilename(name), ".")
+	if name == "" {
+		name = "download"
+	}
+
+	if path.Ext(name) == "" {
+		ext, ok := mediaExtensions[contentType]
+		if !ok {
+			ext = ".mp4"
+		}
+		name += ext
+	}
+	return name
+}
+
+// mediaJobFilename adds the job's ID to a probed filename. Links often share
+// names like video.mp4, and every job needs files of its own.
+func mediaJobFilename(filename, jobID string) string {
+	ext := path.Ext(filename)
+	name := strings.TrimSuffix(filename, ext)
+	// Leave room for the ID within sanitizeFilename's limit
+	if len(name) > 150 {
+		name = name[:150]
+	}
+	return name + "_" + jobID + ext
+}
+
+var mediaExtensions = map[string]string{
+	"video/mp4":        ".mp4",
+	"video/webm":       ".webm",
+	"video/quicktime":  ".mov",
+	"video/x-matroska": ".mkv",
+	"audio/mpeg":       ".mp3",
+	"audio/mp4":        ".m4a",
+	"audio/webm":       ".webm",
+	"audio/ogg":        ".ogg",
+}
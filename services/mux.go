@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/vicradon/yt-downloader/utils"
+)
+
+// StatusMuxing is the status of a job merging its video and audio streams.
+// Both are fully downloaded by then, so it can't be paused.
+const StatusMuxing = "muxing"
+
+// MuxStreams combines separately downloaded video and audio files into
+// outputPath. The inputs are removed once the merge succeeds or fails, but
+// kept when ctx is cancelled so a paused job can still use them.
+func MuxStreams(ctx context.Context, videoPath, audioPath, outputPath string) error {
+	cmd := utils.BuildMuxCommand(ctx, videoPath, audioPath, outputPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		os.Remove(videoPath)
+		os.Remove(audioPath)
+		return fmt.Errorf("ffmpeg mux failed: %w: %s", err, lastLine(output))
+	}
+	os.Remove(videoPath)
+	os.Remove(audioPath)
+	return nil
+}
+
+func lastLine(output []byte) string {
+	end := len(output)
+	for end > 0 && (output[end-1] == '\n' || output[end-1] == '\r') {
+		end--
+	}
+	start := end
+	for start > 0 && output[start-1] != '\n' {
+		start--
+	}
+	return string(output[start:end])
+}
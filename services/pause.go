@@ -0,0 +1,30 @@
+package services
+
+import "errors"
+
+// StatusPaused is the status of a job stopped by PauseJob or PauseDownload.
+// Its partial file and resume offset are kept until it is resumed.
+const StatusPaused = "paused"
+
+var (
+	ErrJobNotPausable = errors.New("job can't be paused")
+	ErrJobNotPaused   = errors.New("job isn't paused")
+	ErrJobStopping    = errors.New("job is still stopping, try again")
+)
+
+// pausable reports whether a job with this status is still waiting or
+// downloading. Jobs can't be paused once ffmpeg runs, whether it merges
+// streams or converts.
+func pausable(status string) bool {
+	switch status {
+	case "queued", "resolving", "downloading", "processing":
+		return true
+	}
+	return false
+}
+
+// stopped reports whether a job was paused or cancelled, so a worker that is
+// still winding down must not change its status.
+func stopped(status string) bool {
+	return status == StatusPaused || status == StatusCancelled
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// progressInterval throttles how often progress is reported, and so
+	// how often it is written to the database.
+	progressInterval = 2 * time.Second
+	// rateSmoothing weighs the newest rate sample against the running
+	// average.
+	rateSmoothing = 0.3
+)
+
+// TransferSnapshot is the state of a download at one point in time.
+type TransferSnapshot struct {
+	Done  int64
+	Total int64
+	// Rate is in bytes per second.
+	Rate float64
+	ETA  time.Duration
+}
+
+// Fraction is how much of the download is done, or 0 when the size is
+// unknown.
+func (s TransferSnapshot) Fraction() float64 {
+	if s.Total <= 0 {
+		return 0
+	}
+	if s.Done >= s.Total {
+		return 1
+	}
+	return float64(s.Done) / float64(s.Total)
+}
+
+// transferProgress counts the bytes of a job's downloads. A job may fetch
+// several files in turn (video then audio), so finished files are added to
+// completed. It is safe for concurrent use by segmented downloads, and a
+// nil *transferProgress ignores every call.
+type transferProgress struct {
+	mu sync.Mutex
+	// expected is the size the provider announced for all files, used
+	// until the servers report their own sizes.
+	expected  int64
+	completed int64
+	done      int64
+	total     int64
+
+	rate       float64
+	sampleAt   time.Time
+	sampleDone int64
+	reportedAt time.Time
+	report     func(TransferSnapshot)
+}
+
+func newTransferProgress(expected int64, report func(TransferSnapshot)) *transferProgress {
+	return &transferProgress{expected: expected, total: -1, report: report}
+}
+
+// startFile is called when a response arrives, with the bytes already on
+// disk and the file size, or -1 when unknown.
+func (p *transferProgress) startFile(done, total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.done = done
+	p.total = total
+	p.sampleAt = time.Now()
+	p.sampleDone = done
+	p.mu.Unlock()
+}
+
+func (p *transferProgress) add(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.done += n
+
+	now := time.Now()
+	if elapsed := now.Sub(p.sampleAt); elapsed >= time.Second {
+		sample := float64(p.done-p.sampleDone) / elapsed.Seconds()
+		if p.rate == 0 {
+			p.rate = sample
+		} else {
+			p.rate = rateSmoothing*sample + (1-rateSmoothing)*p.rate
+		}
+		p.sampleAt = now
+		p.sampleDone = p.done
+	}
+
+	due := now.Sub(p.reportedAt) >= progressInterval
+	if due {
+		p.reportedAt = now
+	}
+	snapshot := p.snapshot()
+	p.mu.Unlock()
+
+	if due && p.report != nil {
+		p.report(snapshot)
+	}
+}
+
+// finishFile moves the current file into completed and reports straight
+// away.
+func (p *transferProgress) finishFile() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.completed += p.done
+	p.done = 0
+	p.total = -1
+	p.reportedAt = time.Now()
+	snapshot := p.snapshot()
+	p.mu.Unlock()
+
+	if p.report != nil {
+		p.report(snapshot)
+	}
+}
+
+// snapshot must be called with p.mu held.
+func (p *transferProgress) snapshot() TransferSnapshot {
+	s := TransferSnapshot{
+		Done: p.completed + p.done,
+		Rate: p.rate,
+	}
+	if p.total >= 0 {
+		s.Total = p.completed + p.total
+	}
+	if p.expected > s.Total {
+		s.Total = p.expected
+	}
+	if s.Rate > 0 && s.Total > s.Done {
+		s.ETA = time.Duration(float64(s.Total-s.Done) / s.Rate * float64(time.Second))
+	}
+	return s
+}
+
+// countingReader reports every read to a transferProgress.
+type countingReader struct {
+	r        io.Reader
+	progress *transferProgress
+}
+
+func (c *countingReader) Read(buf []byte) (int, error) {
+	n, err := c.r.Read(buf)
+	c.progress.add(int64(n))
+	return n, err
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vicradon/yt-downloader/database"
+)
+
+// QueueRunner runs one kind of queued job. RunQueued is called on a worker
+// goroutine and returns when the job has finished or failed.
+type QueueRunner interface {
+	RunQueued(id string)
+}
+
+type queueKey struct {
+	kind string
+	id   string
+}
+
+type queueEntry struct {
+	queueKey
+	queuedAt time.Time
+}
+
+// JobQueue runs jobs in the order they were queued instead of all at once.
+// A job holds one of downloadWorkers slots from the moment it starts until
+// its download is done, and conversions then wait for one of the transcode
+// slots before running ffmpeg. Waiting jobs are stored with a "queued"
+// status, so Restore can pick them up again after a restart.
+type JobQueue struct {
+	mu              sync.Mutex
+	pending         []queueEntry
+	running         map[queueKey]bool
+	downloadWorkers int
+	transcodes      chan struct{}
+	runners         map[string]QueueRunner
+}
+
+func NewJobQueue(downloadWorkers, transcodeWorkers int) *JobQueue {
+	if downloadWorkers < 1 {
+		downloadWorkers = 1
+	}
+	if transcodeWorkers < 1 {
+		transcodeWorkers = 1
+	}
+	return &JobQueue{
+		running:         make(map[queueKey]bool),
+		downloadWorkers: downloadWorkers,
+		transcodes:      make(chan struct{}, transcodeWorkers),
+		runners:         make(map[string]QueueRunner),
+	}
+}
+
+// Register sets the runner for a kind of job. It must be called before jobs
+// of that kind are submitted.
+func (q *JobQueue) Register(kind string, runner QueueRunner) {
+	q.mu.Lock()
+	q.runners[kind] = runner
+	q.mu.Unlock()
+}
+
+// Submit adds a job that has been saved with a "queued" status to the end of
+// the queue.
+func (q *JobQueue) Submit(kind, id string, queuedAt time.Time) {
+	q.mu.Lock()
+	q.add(queueEntry{queueKey{kind, id}, queuedAt})
+	q.dispatch()
+	q.mu.Unlock()
+}
+
+// Restore loads the jobs left queued in Postgres, for example by a restart,
+// and runs them in the order they were queued.
+func (q *JobQueue) Restore() error {
+	jobs, err := database.LoadQueuedJobs()
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range jobs {
+		q.add(queueEntry{queueKey{job.Kind, job.ID}, job.QueuedAt})
+	}
+	sort.SliceStable(q.pending, func(i, j int) bool {
+		return q.pending[i].queuedAt.Before(q.pending[j].queuedAt)
+	})
+
+	if len(jobs) > 0 {
+		log.Printf("Job queue: restored %d queued jobs", len(jobs))
+	}
+	q.dispatch()
+	return nil
+}
+
+// Position is a waiting job's 1-based place in the queue, or 0 when it isn't
+// waiting.
+func (q *JobQueue) Position(kind, id string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, entry := range q.pending {
+		if entry.queueKey == (queueKey{kind, id}) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// FinishDownload frees the job's download slot for the next job. It is safe
+// to call more than once.
+func (q *JobQueue) FinishDownload(kind, id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := queueKey{kind, id}
+	if q.running[key] {
+		delete(q.running, key)
+		q.dispatch()
+	}
+}
+
+// Remove takes a job out of the queue before it has started. It reports
+// false when the job wasn't waiting.
+func (q *JobQueue) Remove(kind, id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, entry := range q.pending {
+		if entry.queueKey == (queueKey{kind, id}) {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// AcquireTranscode blocks until an ffmpeg slot is free or ctx is cancelled.
+// Waiting jobs get a slot in the order they asked for one.
+func (q *JobQueue) AcquireTranscode(ctx context.Context) error {
+	select {
+	case q.transcodes <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *JobQueue) ReleaseTranscode() {
+	<-q.transcodes
+}
+
+// add must be called with q.mu held. Jobs already waiting or running are
+// ignored.
+func (q *JobQueue) add(entry queueEntry) {
+	if q.running[entry.queueKey] {
+		return
+	}
+	for _, pending := range q.pending {
+		if pending.queueKey == entry.queueKey {
+			return
+		}
+	}
+	q.pending = append(q.pending, entry)
+}
+
+// dispatch starts waiting jobs while there are free download slots. It must
+// be called with q.mu held.
+func (q *JobQueue) dispatch() {
+	for len(q.running) < q.downloadWorkers && len(q.pending) > 0 {
+		entry := q.pending[0]
+		q.pending = q.pending[1:]
+
+		runner, ok := q.runners[entry.kind]
+		if !ok {
+			log.Printf("Job queue: no runner for %s job %s", entry.kind, entry.id)
+			continue
+		}
+
+		q.running[entry.queueKey] = true
+		go func(key queueKey) {
+			runner.RunQueued(key.id)
+			q.FinishDownload(key.kind, key.id)
+		}(entry.queueKey)
+	}
+}
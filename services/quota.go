@@ -0,0 +1,136 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vicradon/yt-downloader/database"
+	"github.com/vicradon/yt-downloader/models"
+)
+
+var ErrQuotaExceeded = errors.New("provider quota exhausted")
+
+// QuotaTracker follows the usage of a single API key: what the provider
+// reports in its x-ratelimit-* headers and how many requests we have made
+// today.
+type QuotaTracker struct {
+	keyID     string
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	resetAt   *time.Time
+	day       time.Time
+	usedToday int
+	loaded    bool
+}
+
+func NewQuotaTracker(apiKey string) *QuotaTracker {
+	return &QuotaTracker{
+		keyID:     apiKeyID(apiKey),
+		remaining: -1,
+	}
+}
+
+// apiKeyID identifies a key in the database without storing the key itself.
+func apiKeyID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// syncDay reloads today's counter when the day changes or on first use.
+// Callers must hold t.mu.
+func (t *QuotaTracker) syncDay() {
+	day := today()
+	if t.loaded && t.day.Equal(day) {
+		return
+	}
+
+	used, err := database.GetAPIUsage(t.keyID, day)
+	if err != nil {
+		log.Printf("Failed to load API usage for key %s: %v", t.keyID, err)
+	}
+	t.day = day
+	t.usedToday = used
+	t.loaded = true
+}
+
+// RecordRequest counts a request against today's usage and stores the
+// provider's rate limit headers.
+func (t *QuotaTracker) RecordRequest(header http.Header) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.syncDay()
+	t.usedToday++
+	if err := database.IncrementAPIUsage(t.keyID, t.day); err != nil {
+		log.Printf("Failed to store API usage for key %s: %v", t.keyID, err)
+	}
+
+	if limit, err := strconv.Atoi(header.Get("x-ratelimit-requests-limit")); err == nil {
+		t.limit = limit
+	}
+	if remaining, err := strconv.Atoi(header.Get("x-ratelimit-requests-remaining")); err == nil {
+		t.remaining = remaining
+	}
+	if reset, err := strconv.Atoi(header.Get("x-ratelimit-requests-reset")); err == nil {
+		resetAt := time.Now().Add(time.Duration(reset) * time.Second)
+		t.resetAt = &resetAt
+	}
+}
+
+// MarkExhausted is used when the provider answers 429. Without a reset time
+// from the provider the quota is assumed back after cooldown.
+func (t *QuotaTracker) MarkExhausted(cooldown time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.remaining = 0
+	if now := time.Now(); t.resetAt == nil || !t.resetAt.After(now) {
+		resetAt := now.Add(cooldown)
+		t.resetAt = &resetAt
+	}
+}
+
+// Exhausted reports whether the provider said we have no requests left and
+// the reset time hasn't passed yet. With no reset time known the quota is
+// taken to be available, so a key is never shut out for good.
+func (t *QuotaTracker) Exhausted() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.remaining != 0 {
+		return false
+	}
+	return t.resetAt != nil && time.Now().Before(*t.resetAt)
+}
+
+func (t *QuotaTracker) UsedToday() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.syncDay()
+	return t.usedToday
+}
+
+func (t *QuotaTracker) Status() models.QuotaStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.syncDay()
+	return models.QuotaStatus{
+		Limit:     t.limit,
+		Remaining: t.remaining,
+		ResetAt:   t.resetAt,
+		UsedToday: t.usedToday,
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	FileStateWaiting  = "waiting"
+	FileStateReady    = "ready"
+	FileStateTimedOut = "timed_out"
+)
+
+// ReadinessProber polls a provider file URL until it can actually be
+// fetched. Providers such as RapidAPI hand out the URL before the file has
+// been generated, so downloading straight away returns 404s.
+type ReadinessProber struct {
+	client       *http.Client
+	deadline     time.Duration
+	initialDelay time.Duration
+	maxDelay     time.Duration
+}
+
+func NewReadinessProber(deadline time.Duration) *ReadinessProber {
+	return &ReadinessProber{
+		client:       &http.Client{Timeout: 15 * time.Second},
+		deadline:     deadline,
+		initialDelay: time.Second,
+		maxDelay:     15 * time.Second,
+	}
+}
+
+// WaitUntilReady probes the URLs of one file, a primary and its mirrors, with
+// exponential backoff until one of them responds or the deadline passes.
+// onState is called whenever the state changes.
+func (p *ReadinessProber) WaitUntilReady(ctx context.Context, fileURLs []string, onState func(state string)) error {
+	onState(FileStateWaiting)
+
+	deadline := time.Now().Add(p.deadline)
+	delay := p.initialDelay
+
+	for attempt := 1; ; attempt++ {
+		for _, fileURL := range fileURLs {
+			ready, err := p.probe(ctx, fileURL)
+			if ready {
+				onState(FileStateReady)
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				log.Printf("Readiness probe %d for %s: %v", attempt, fileURL, err)
+			}
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			onState(FileStateTimedOut)
+			return fmt.Errorf("file not ready after %s", p.deadline)
+		}
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+		delay *= 2
+		if delay > p.maxDelay {
+			delay = p.maxDelay
+		}
+	}
+}
+
+// WaitUntilAllReady waits for each file in turn, given as the URLs it can be
+// fetched from. Files without a URL are skipped.
+func (p *ReadinessProber) WaitUntilAllReady(ctx context.Context, files [][]string, onState func(state string)) error {
+	for _, fileURLs := range files {
+		if len(fileURLs) == 0 || fileURLs[0] == "" {
+			continue
+		}
+		if err := p.WaitUntilReady(ctx, fileURLs, onState); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probe tries a HEAD request first and falls back to a 1-byte range GET for
+// servers that don't allow HEAD.
+func (p *ReadinessProber) probe(ctx context.Context, fileURL string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fileURL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && resp.ContentLength != 0 {
+			return true, nil
+		}
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusForbidden {
+			return false, fmt.Errorf("HEAD returned status %d", resp.StatusCode)
+		}
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err = p.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		return true, nil
+	}
+	return false, fmt.Errorf("range GET returned status %d", resp.StatusCode)
+}
+
+// sleepContext sleeps for d, returning early with ctx's error when it is
+// cancelled.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vicradon/yt-downloader/database"
+	"github.com/vicradon/yt-downloader/models"
+)
+
+// StatusInterrupted is the final status of a job that was running when the
+// process stopped and isn't picked up again by itself.
+const StatusInterrupted = "interrupted"
+
+// inFlightStatuses are the statuses a job only has while a worker runs it.
+var inFlightStatuses = []string{"resolving", "downloading", StatusMuxing, "converting", "processing"}
+
+// Reconciler sorts out the jobs that were running when the process last
+// stopped. Nothing runs them any more, so without it they would keep their
+// status forever.
+type Reconciler struct {
+	conversionService     *ConversionService
+	directDownloadService *DirectDownloadService
+	ongoingDir            string
+}
+
+func NewReconciler(conversionService *ConversionService, directDownloadService *DirectDownloadService, ongoingDir string) *Reconciler {
+	return &Reconciler{
+		conversionService:     conversionService,
+		directDownloadService: directDownloadService,
+		ongoingDir:            ongoingDir,
+	}
+}
+
+// Run must be called once at startup, after the conversions are loaded and
+// before the queue is restored. Jobs that were downloading are queued again
+// in their old place and resume from their partial file when it checks out.
+// Conversions that were running ffmpeg are queued again to convert the file
+// they had downloaded; only when that file is gone are they marked
+// interrupted, to be retried. Temporary files that nothing can use any more
+// are removed.
+func (r *Reconciler) Run() error {
+	jobs, err := database.LoadInFlightJobs(inFlightStatuses)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		switch job.Kind {
+		case models.QueueKindConversion:
+			r.conversionService.recoverJob(job.ID)
+		case models.QueueKindDownload:
+			r.directDownloadService.recoverDownload(job.ID)
+		}
+	}
+	if len(jobs) > 0 {
+		log.Printf("Recovery: found %d jobs interrupted by a restart", len(jobs))
+	}
+
+	r.removeLeftovers()
+	return nil
+}
+
+// removeLeftovers deletes ffmpeg outputs that were never published and audio
+// streams, which are always fetched again from the start.
+func (r *Reconciler) removeLeftovers() {
+	entries, err := os.ReadDir(r.ongoingDir)
+	if err != nil {
+		log.Printf("Recovery: failed to read %s: %v", r.ongoingDir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.Contains(name, ".converted.") || strings.HasSuffix(name, ".audio")) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.ongoingDir, name)); err != nil {
+			log.Printf("Recovery: failed to remove %s: %v", name, err)
+			continue
+		}
+		log.Printf("Recovery: removed leftover %s", name)
+	}
+}
+
+// checkPartialFile makes sure a resume offset matches the partial file on
+// disk. A file that can't be resumed is removed and the offset reset, so the
+// download starts over. It returns the offset to resume from.
+func checkPartialFile(path string, offset int64) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	// Segmented downloads leave a file with holes and no offset
+	if offset == 0 || info.Size() < offset {
+		os.Remove(path)
+		return 0
+	}
+	return offset
+}
+
+// recoverJob queues a conversion that was interrupted again: a download
+// resumes from its partial file and a conversion starts over on the file it
+// had downloaded. It marks the job interrupted when the file to convert or
+// the upload is gone.
+func (s *ConversionService) recoverJob(jobID string) {
+	job, exists := s.GetJob(jobID)
+	if !exists {
+		return
+	}
+
+	job.Mu.Lock()
+	status, source, url := job.Status, job.Source, job.URL
+	sanitizedTitle := jobFileTitle(job.ID, job.VideoTitle)
+	hasAudio := job.AudioURL != ""
+	inputPath := job.InputPath
+	job.Mu.Unlock()
+
+	partialFile := filepath.Join(s.ongoingDir, sanitizedTitle+".mp4")
+	if hasAudio {
+		partialFile = filepath.Join(s.ongoingDir, sanitizedTitle+".video")
+	}
+
+	inputReady := false
+	if inputPath != "" {
+		_, err := os.Stat(inputPath)
+		inputReady = err == nil
+	}
+
+	switch {
+	case status == "converting" && !inputReady:
+		s.markJobInterrupted(job, "Interrupted while converting")
+		return
+	case source == JobSourceUpload:
+		if _, err := os.Stat(s.uploadPath(jobID, url)); err != nil {
+			s.markJobInterrupted(job, "Uploaded file is gone")
+			return
+		}
+	}
+
+	now := time.Now()
+
+	// A muxed file may be half-written, and the video is resumed. Once it
+	// is the input to convert it is complete.
+	if hasAudio && !inputReady {
+		os.Remove(filepath.Join(s.ongoingDir, sanitizedTitle+".mkv"))
+	}
+
+	job.Mu.Lock()
+	if !inputReady {
+		job.InputPath = ""
+	}
+	if source != JobSourceUpload && !inputReady {
+		job.ResumeOffset = checkPartialFile(partialFile, job.ResumeOffset)
+		if job.ResumeOffset == 0 {
+			job.ResumeETag = ""
+		}
+	}
+	// The same stream is resolved again, so the partial file still fits
+	if job.Itag != 0 {
+		job.Quality = models.QualitySelection{Itag: job.Itag}
+	}
+	// Jobs keep their place ahead of the ones queued after them
+	if job.QueuedAt == nil {
+		job.QueuedAt = &now
+	}
+	job.Status = "queued"
+	job.TransferRate = 0
+	job.ETASeconds = 0
+	database.SaveConversion(job)
+	offset := job.ResumeOffset
+	job.Mu.Unlock()
+
+	if inputReady {
+		log.Printf("Job %s: Queued again after a restart to convert %s", jobID, filepath.Base(inputPath))
+		return
+	}
+	log.Printf("Job %s: Queued again after a restart, resuming at byte %d", jobID, offset)
+}
+
+// markJobInterrupted ends a job that can't carry on after a restart and
+// removes its partial files.
+func (s *ConversionService) markJobInterrupted(job *models.ConversionJob, reason string) {
+	s.removePartialFiles(job)
+
+	errorMsg := reason + ": " + ErrInterrupted.Error()
+
+	job.Mu.Lock()
+	job.Status = StatusInterrupted
+	job.Error = &errorMsg
+	job.ErrorCode = ErrorCodeInterrupted
+	job.ResumeOffset = 0
+	job.ResumeETag = ""
+	job.TransferRate = 0
+	job.ETASeconds = 0
+	endTime := time.Now()
+	job.EndTime = &endTime
+	database.SaveConversion(job)
+	batchID := job.BatchID
+	job.Mu.Unlock()
+
+	updateBatchProgress(batchID)
+
+	log.Printf("Job %s: %s", job.ID, errorMsg)
+}
+
+// recoverDownload queues a direct download that was interrupted again. There
+// is no ffmpeg run to worry about beyond muxing, so downloads are always
+// picked up again.
+func (s *DirectDownloadService) recoverDownload(id string) {
+	download, err := s.loadDownload(id)
+	if err != nil {
+		log.Printf("Download %s: failed to load for recovery: %v", id, err)
+		return
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	if download.Filename != "" {
+		partialFile := filepath.Join(s.tempDir, download.Filename)
+		if download.AudioURL != "" {
+			// A muxed file may be half-written, and the video is resumed
+			os.Remove(partialFile)
+			partialFile += ".video"
+		}
+		download.ResumeOffset = checkPartialFile(partialFile, download.ResumeOffset)
+	} else {
+		download.ResumeOffset = 0
+	}
+	if download.ResumeOffset == 0 {
+		download.ResumeETag = ""
+	}
+	if download.Itag != 0 {
+		download.Quality = models.QualitySelection{Itag: download.Itag}
+	}
+	if download.QueuedAt == nil {
+		download.QueuedAt = &now
+	}
+	download.Status = "queued"
+	download.TransferRate = 0
+	download.ETASeconds = 0
+	download.UpdatedAt = now
+	offset := download.ResumeOffset
+	err = database.SaveDirectDownload(download)
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to update download in database: %v", err)
+	}
+
+	log.Printf("Download %s: Queued again after a restart, resuming at byte %d", id, offset)
+}
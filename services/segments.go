@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxDownloadSegments caps parallel connections to a single server.
+	MaxDownloadSegments = 16
+	// minSegmentSize keeps small files from being split into tiny ranges.
+	minSegmentSize = 1 << 20
+)
+
+type segment struct {
+	start int64
+	end   int64
+	done  int64
+}
+
+// fetchSegmented downloads downloadURL as task.Segments byte ranges fetched
+// in parallel into a preallocated file. ok is false when the server doesn't
+// support ranges or the file is too small to split; the caller should fall
+// back to a single stream then.
+func fetchSegmented(ctx context.Context, task *downloadTask, downloadURL string) (bool, error) {
+	total, etag, ok := probeRanges(ctx, task.client(), downloadURL)
+	if !ok || total < 2*minSegmentSize {
+		return false, nil
+	}
+	if task.MaxSize > 0 && total > task.MaxSize {
+		return true, task.errTooLarge()
+	}
+
+	n := task.Segments
+	if n > MaxDownloadSegments {
+		n = MaxDownloadSegments
+	}
+	if most := int(total / minSegmentSize); n > most {
+		n = most
+	}
+
+	out, err := os.Create(task.OutputPath)
+	if err != nil {
+		return true, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer out.Close()
+
+	if err := out.Truncate(total); err != nil {
+		return true, fmt.Errorf("failed to allocate file: %w", err)
+	}
+
+	// The file has holes until every segment is in, so it can only be
+	// resumed from the end of the first unfinished segment
+	task.Offset, task.ETag = 0, ""
+	task.checkpoint()
+
+	log.Printf("%s: Downloading %d bytes in %d segments", task.LogPrefix, total, n)
+	task.Progress.startFile(0, total)
+
+	size := total / int64(n)
+	segs := make([]*segment, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		seg := &segment{start: int64(i) * size, end: int64(i+1)*size - 1}
+		if i == n-1 {
+			seg.end = total - 1
+		}
+		segs[i] = seg
+
+		wg.Add(1)
+		go func(i int, seg *segment) {
+			defer wg.Done()
+			errs[i] = fetchSegment(ctx, out, downloadURL, etag, seg, task)
+		}(i, seg)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		// A later attempt, or a paused job once it is resumed, continues
+		// from the bytes that are already in one piece as a single stream
+		task.Offset, task.ETag = contiguousBytes(segs), etag
+		task.checkpoint()
+		return true, err
+	}
+
+	task.Offset, task.ETag = total, etag
+	return true, nil
+}
+
+// contiguousBytes counts the bytes written from the start of the file up to
+// the first gap.
+func contiguousBytes(segs []*segment) int64 {
+	var n int64
+	for _, seg := range segs {
+		n += seg.done
+		if seg.start+seg.done <= seg.end {
+			break
+		}
+	}
+	return n
+}
+
+// probeRanges asks for the first byte to learn whether the server supports
+// ranges and how big the file is.
+func probeRanges(ctx context.Context, client *http.Client, downloadURL string) (int64, string, bool) {
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return 0, "", false
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	probeClient := *client
+	probeClient.Timeout = 15 * time.Second
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return 0, "", false
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, "", false
+	}
+	total := contentRangeTotal(resp.Header.Get("Content-Range"))
+	return total, resp.Header.Get("ETag"), total > 0
+}
+
+// fetchSegment retries a segment on its own, continuing from the bytes it
+// already wrote.
+func fetchSegment(ctx context.Context, out *os.File, downloadURL, etag string, seg *segment, task *downloadTask) error {
+	maxRetries := 3
+
+	var err error
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleepContext(ctx, time.Duration(5*attempt)*time.Second); err != nil {
+				return err
+			}
+		}
+
+		if err = fetchSegmentOnce(ctx, out, downloadURL, etag, seg, task); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("%s: Segment %d-%d attempt %d failed at byte %d: %v", task.LogPrefix, seg.start, seg.end, attempt+1, seg.start+seg.done, err)
+	}
+	return fmt.Errorf("segment %d-%d: %w", seg.start, seg.end, err)
+}
+
+func fetchSegmentOnce(ctx context.Context, out *os.File, downloadURL, etag string, seg *segment, task *downloadTask) error {
+	from := seg.start + seg.done
+
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, seg.end))
+	if etag != "" {
+		req.Header.Set("If-Range", etag)
+	}
+
+	resp, err := task.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("expected partial content, got status %d", resp.StatusCode)
+	}
+	if start := contentRangeStart(resp.Header.Get("Content-Range")); start != from {
+		return fmt.Errorf("server sent range starting at %d, want %d", start, from)
+	}
+
+	// Nothing past the end of the segment is read, whatever the server sends
+	limited := io.LimitReader(resp.Body, seg.end-from+2)
+	body := &countingReader{r: &throttledReader{ctx: ctx, r: limited, throttle: task.Throttle}, progress: task.Progress}
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := body.Read(buf)
+		if remaining := seg.end - (seg.start + seg.done) + 1; int64(n) > remaining {
+			n = int(remaining)
+		}
+		if n > 0 {
+			if _, err := out.WriteAt(buf[:n], seg.start+seg.done); err != nil {
+				return err
+			}
+			seg.done += int64(n)
+		}
+		if seg.start+seg.done > seg.end {
+			return nil
+		}
+		if readErr == io.EOF {
+			return fmt.Errorf("connection closed %d bytes early", seg.end-(seg.start+seg.done)+1)
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/vicradon/yt-downloader/models"
+)
+
+// VideoSource resolves a user supplied URL to a media file that can be
+// downloaded. Each backend (RapidAPI, test fakes, ...) implements it.
+type VideoSource interface {
+	Name() string
+	ExtractVideoID(url string) (string, error)
+	Resolve(videoID string, itag int) (*models.MediaStream, error)
+	Metadata(videoID string) (*models.VideoMetadata, error)
+	Formats(videoID string) ([]models.MediaStream, error)
+}
+
+type SourceRegistry struct {
+	sources []VideoSource
+	mu      sync.RWMutex
+}
+
+func NewSourceRegistry(sources ...VideoSource) *SourceRegistry {
+	return &SourceRegistry{sources: sources}
+}
+
+func (r *SourceRegistry) Register(source VideoSource) {
+	r.mu.Lock()
+	r.sources = append(r.sources, source)
+	r.mu.Unlock()
+}
+
+// QuotaGuard is implemented by sources that spend a metered API quota.
+type QuotaGuard interface {
+	QuotaExhausted() bool
+	QuotaStatus() models.QuotaStatus
+}
+
+// Quotas returns the quota status of every metered source by name.
+func (r *SourceRegistry) Quotas() map[string]models.QuotaStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	quotas := make(map[string]models.QuotaStatus)
+	for _, source := range r.sources {
+		sources := []VideoSource{source}
+		if chain, ok := source.(*ResolverChain); ok {
+			sources = chain.Providers()
+		}
+		for _, s := range sources {
+			if guard, ok := s.(QuotaGuard); ok {
+				quotas[s.Name()] = guard.QuotaStatus()
+			}
+		}
+	}
+	return quotas
+}
+
+// ReportFailure tells the resolver chains that a URL handed out by provider
+// could not be downloaded, so retries prefer a healthier provider.
+func (r *SourceRegistry) ReportFailure(provider string) {
+	if provider == "" {
+		return
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, source := range r.sources {
+		if chain, ok := source.(*ResolverChain); ok {
+			chain.ReportFailure(provider)
+		}
+	}
+}
+
+// Demote tells the resolver chains to try provider last when videoID is
+// resolved again.
+func (r *SourceRegistry) Demote(videoID, provider string) {
+	if provider == "" {
+		return
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, source := range r.sources {
+		if chain, ok := source.(*ResolverChain); ok {
+			chain.Demote(videoID, provider)
+		}
+	}
+}
+
+// PlaylistSource is implemented by sources that can expand a playlist URL
+// into its member videos.
+type PlaylistSource interface {
+	ExtractPlaylistID(url string) (string, bool)
+	PlaylistVideos(playlistID string) (string, []models.VideoMetadata, error)
+}
+
+// LookupPlaylist returns the first source that recognises url as a
+// playlist, along with the playlist ID.
+func (r *SourceRegistry) LookupPlaylist(url string) (PlaylistSource, string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, source := range r.sources {
+		playlistSource, ok := source.(PlaylistSource)
+		if !ok {
+			continue
+		}
+		if playlistID, ok := playlistSource.ExtractPlaylistID(url); ok {
+			return playlistSource, playlistID, true
+		}
+	}
+	return nil, "", false
+}
+
+// Lookup returns the first registered source that recognises the URL,
+// along with the video ID it extracted.
+func (r *SourceRegistry) Lookup(url string) (VideoSource, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, source := range r.sources {
+		if videoID, err := source.ExtractVideoID(url); err == nil {
+			return source, videoID, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("%w: no video source supports this URL", ErrInvalidVideoID)
+}
+
+type resolvedMedia struct {
+	Stream *models.MediaStream
+	// Audio is set when Stream is video-only and has to be muxed with a
+	// separate audio stream.
+	Audio *models.MediaStream
+	Title string
+}
+
+// resolveMedia resolves the stream matching the requested quality, plus a
+// matching audio stream when it is video-only, and works out a title for it,
+// falling back to the source metadata and finally the video ID.
+func resolveMedia(source VideoSource, videoID string, quality models.QualitySelection) (*resolvedMedia, error) {
+	itag, err := resolveItag(source, videoID, quality)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := source.Resolve(videoID, itag)
+	if err != nil {
+		return nil, err
+	}
+	if stream.Provider == "" {
+		stream.Provider = source.Name()
+	}
+
+	var audio *models.MediaStream
+	if StreamKind(stream) == StreamVideoOnly {
+		audio, err = source.Resolve(videoID, matchingAudioItag(stream))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve audio stream: %w", err)
+		}
+	}
+
+	videoTitle := stream.Title
+	if videoTitle == "" {
+		if metadata, err := source.Metadata(videoID); err == nil {
+			videoTitle = metadata.Title
+		} else {
+			log.Printf("Warning: could not fetch video title for %s: %v", videoID, err)
+			videoTitle = videoID
+		}
+	}
+
+	return &resolvedMedia{Stream: stream, Audio: audio, Title: videoTitle}, nil
+}
+
+func (r *SourceRegistry) Get(name string) (VideoSource, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, source := range r.sources {
+		if source.Name() == name {
+			return source, true
+		}
+	}
+	return nil, false
+}
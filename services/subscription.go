@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vicradon/yt-downloader/database"
+	"github.com/vicradon/yt-downloader/models"
+	"github.com/vicradon/yt-downloader/utils"
+)
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+type SubscriptionService struct {
+	sources               *SourceRegistry
+	conversionService     *ConversionService
+	directDownloadService *DirectDownloadService
+	interval              time.Duration
+}
+
+func NewSubscriptionService(sources *SourceRegistry, conversionService *ConversionService, directDownloadService *DirectDownloadService, interval time.Duration) *SubscriptionService {
+	return &SubscriptionService{
+		sources:               sources,
+		conversionService:     conversionService,
+		directDownloadService: directDownloadService,
+		interval:              interval,
+	}
+}
+
+// Start polls every enabled subscription on a fixed interval until the
+// process exits.
+func (s *SubscriptionService) Start() {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.PollAll()
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *SubscriptionService) PollAll() {
+	subscriptions, err := database.LoadSubscriptions()
+	if err != nil {
+		log.Printf("Failed to load subscriptions: %v", err)
+		return
+	}
+
+	for i := range subscriptions {
+		if subscriptions[i].Enabled {
+			s.poll(&subscriptions[i])
+		}
+	}
+}
+
+// poll reads the subscription feed and enqueues every video it hasn't seen.
+func (s *SubscriptionService) poll(subscription *models.Subscription) {
+	feed, err := fetchFeed(subscription.FeedURL)
+
+	now := time.Now()
+	subscription.LastCheckedAt = &now
+	if err != nil {
+		errMsg := err.Error()
+		subscription.LastError = &errMsg
+		database.SaveSubscription(subscription)
+		log.Printf("Subscription %d: failed to fetch feed: %v", subscription.ID, err)
+		return
+	}
+	subscription.LastError = nil
+	if subscription.Title == "" {
+		subscription.Title = feed.Title
+	}
+	database.SaveSubscription(subscription)
+
+	for _, entry := range feed.Entries {
+		seen, err := database.VideoSeen(subscription.ID, entry.VideoID)
+		if err != nil {
+			log.Printf("Subscription %d: failed to look up %s: %v", subscription.ID, entry.VideoID, err)
+			continue
+		}
+		if seen {
+			continue
+		}
+
+		// Videos are only marked seen once their job is saved, so a video
+		// whose job couldn't be created is tried again on the next poll
+		if err := s.enqueue(subscription, entry.VideoID); err != nil {
+			log.Printf("Subscription %d: failed to enqueue %s: %v", subscription.ID, entry.VideoID, err)
+			continue
+		}
+		if _, err := database.MarkVideoSeen(subscription.ID, entry.VideoID); err != nil {
+			log.Printf("Subscription %d: failed to record %s: %v", subscription.ID, entry.VideoID, err)
+		}
+	}
+}
+
+func (s *SubscriptionService) enqueue(subscription *models.Subscription, videoID string) error {
+	videoURL := "https://www.youtube.com/watch?v=" + videoID
+	_, videoID, err := s.sources.Lookup(videoURL)
+	if err != nil {
+		return err
+	}
+
+	jobID := fmt.Sprintf("%s_%d", videoID, time.Now().Unix())
+	if subscription.Convert {
+		job, err := s.conversionService.createJob(jobID, videoURL, subscription.Format, "", 0, 0)
+		if err != nil {
+			return fmt.Errorf("failed to save job: %w", err)
+		}
+		s.conversionService.Enqueue(job, subscription.Quality())
+	} else {
+		download, err := s.directDownloadService.createDownload(jobID, videoURL, "", 0, 0)
+		if err != nil {
+			return fmt.Errorf("failed to save download: %w", err)
+		}
+		s.directDownloadService.Enqueue(download, subscription.Quality())
+	}
+
+	log.Printf("Subscription %d: enqueued %s as %s", subscription.ID, videoID, jobID)
+	return nil
+}
+
+// CreateSubscription stores a new subscription. Videos already in the feed
+// are marked as seen so only uploads from now on are downloaded.
+func (s *SubscriptionService) CreateSubscription(req models.SubscriptionRequest) (*models.Subscription, error) {
+	if err := validateSubscriptionRequest(req); err != nil {
+		return nil, err
+	}
+
+	feedURL, err := subscriptionFeedURL(req.ChannelID, req.FeedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	feed, err := fetchFeed(feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed: %w", err)
+	}
+
+	subscription := &models.Subscription{
+		ChannelID: req.ChannelID,
+		FeedURL:   feedURL,
+		Title:     feed.Title,
+		Enabled:   true,
+	}
+	applySubscriptionRequest(subscription, req)
+
+	if err := database.SaveSubscription(subscription); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range feed.Entries {
+		if _, err := database.MarkVideoSeen(subscription.ID, entry.VideoID); err != nil {
+			log.Printf("Subscription %d: failed to record %s: %v", subscription.ID, entry.VideoID, err)
+		}
+	}
+
+	return subscription, nil
+}
+
+func (s *SubscriptionService) UpdateSubscription(id uint, req models.SubscriptionRequest) (*models.Subscription, error) {
+	if err := validateSubscriptionRequest(req); err != nil {
+		return nil, err
+	}
+
+	subscription, err := database.GetSubscription(id)
+	if err != nil {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	applySubscriptionRequest(subscription, req)
+
+	if err := database.SaveSubscription(subscription); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (s *SubscriptionService) GetSubscription(id uint) (*models.Subscription, error) {
+	subscription, err := database.GetSubscription(id)
+	if err != nil {
+		return nil, ErrSubscriptionNotFound
+	}
+	return subscription, nil
+}
+
+func (s *SubscriptionService) GetAllSubscriptions() ([]models.Subscription, error) {
+	return database.LoadSubscriptions()
+}
+
+func (s *SubscriptionService) DeleteSubscription(id uint) error {
+	return database.DeleteSubscription(id)
+}
+
+func applySubscriptionRequest(subscription *models.Subscription, req models.SubscriptionRequest) {
+	subscription.Format = req.Format
+	subscription.Convert = req.Convert
+	subscription.MaxHeight = req.Quality.MaxHeight
+	subscription.Codec = req.Quality.Codec
+	subscription.Container = req.Quality.Container
+	subscription.Itag = req.Quality.Itag
+	if req.Enabled != nil {
+		subscription.Enabled = *req.Enabled
+	}
+}
+
+func validateSubscriptionRequest(req models.SubscriptionRequest) error {
+	if req.Convert && !utils.IsConversionFormat(req.Format) {
+		return fmt.Errorf("format must be one of %s when converting", strings.Join(utils.ConversionFormats, ", "))
+	}
+	return nil
+}
+
+// subscriptionFeedURL builds the Atom feed URL for a channel ID, or
+// validates a feed URL given directly. Only YouTube's own feeds are
+// accepted, since the server fetches them on every poll.
+func subscriptionFeedURL(channelID, feedURL string) (string, error) {
+	if feedURL != "" {
+		u, err := url.Parse(feedURL)
+		if err != nil || u.Scheme != "https" || !youtubeHosts[strings.ToLower(u.Hostname())] || u.Path != "/feeds/videos.xml" {
+			return "", fmt.Errorf("feed URL must be a https://www.youtube.com/feeds/videos.xml feed")
+		}
+		return feedURL, nil
+	}
+
+	if !strings.HasPrefix(channelID, "UC") || len(channelID) != 24 {
+		return "", fmt.Errorf("channel ID must look like UCxxxxxxxxxxxxxxxxxxxxxx")
+	}
+	return "https://www.youtube.com/feeds/videos.xml?channel_id=" + channelID, nil
+}
@@ -4,90 +4,281 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
+
 	"github.com/vicradon/yt-downloader/models"
 )
 
 type YouTubeService struct {
-	APIKey  string
-	APIHost string
+	// Keys holds the RapidAPI keys requests are spread across.
+	Keys *KeyPool
+	// CacheTTL is used for cached download URLs that don't carry their own
+	// expiry.
+	CacheTTL time.Duration
+	// DailyBudget caps the RapidAPI requests made per day. 0 means no cap.
+	DailyBudget int
+	// Playlists expands playlists that are too long for the Atom feed. The
+	// feed is still used when it is nil or fails.
+	Playlists PlaylistSource
 }
 
 func NewYouTubeService(apiKey, apiHost string) *YouTubeService {
+	return NewYouTubeServiceWithKeys(NewKeyPool([]string{apiKey}, []string{apiHost}, KeyStrategyRoundRobin, 15*time.Minute))
+}
+
+func NewYouTubeServiceWithKeys(keys *KeyPool) *YouTubeService {
 	return &YouTubeService{
-		APIKey:  apiKey,
-		APIHost: apiHost,
+		Keys:     keys,
+		CacheTTL: time.Hour,
 	}
 }
 
+func (s *YouTubeService) Name() string {
+	return "rapidapi"
+}
+
 func (s *YouTubeService) ExtractVideoID(url string) (string, error) {
-	if strings.Contains(url, "youtu.be/") {
-		parts := strings.Split(url, "youtu.be/")
-		if len(parts) > 1 {
-			videoID := strings.Split(parts[1], "?")[0]
-			if videoID != "" {
-				return videoID, nil
-			}
-		}
+	parsed, err := ParseYouTubeURL(url)
+	if err != nil {
+		return "", err
 	}
+	if parsed.VideoID == "" {
+		return "", fmt.Errorf("could not extract video ID from URL")
+	}
+	return parsed.VideoID, nil
+}
 
-	if strings.Contains(url, "youtube.com/watch") {
-		parsedURL := strings.Split(url, "?")
-		if len(parsedURL) > 1 {
-			params := strings.Split(parsedURL[1], "&")
-			for _, param := range params {
-				if strings.HasPrefix(param, "v=") {
-					return strings.TrimPrefix(param, "v="), nil
-				}
-			}
+func (s *YouTubeService) ExtractPlaylistID(url string) (string, bool) {
+	return extractPlaylistID(url)
+}
+
+// extractPlaylistID only treats links to a playlist itself as playlists. A
+// watch link that carries a list still means that one video, and mixes
+// (RD lists) are generated per viewer and have no feed to expand.
+func extractPlaylistID(url string) (string, bool) {
+	parsed, err := ParseYouTubeURL(url)
+	if err != nil || parsed.PlaylistID == "" || parsed.VideoID != "" {
+		return "", false
+	}
+	if strings.HasPrefix(parsed.PlaylistID, "RD") {
+		return "", false
+	}
+	return parsed.PlaylistID, true
+}
+
+// playlistFeedLimit is how many entries YouTube puts in a playlist's Atom
+// feed. A full feed means the playlist may have been cut short.
+const playlistFeedLimit = 15
+
+// PlaylistVideos lists the videos of a playlist through Playlists when it is
+// set, which pages through playlists of any length. Otherwise, or when that
+// fails, it falls back to the public Atom feed, which needs no Data API key.
+// The feed stops at playlistFeedLimit entries, so longer playlists are
+// refused rather than expanded in part.
+func (s *YouTubeService) PlaylistVideos(playlistID string) (string, []models.VideoMetadata, error) {
+	if s.Playlists != nil {
+		title, videos, err := s.Playlists.PlaylistVideos(playlistID)
+		if err == nil {
+			return title, videos, nil
 		}
+		log.Printf("Playlist %s: falling back to the Atom feed: %v", playlistID, err)
 	}
 
-	return "", fmt.Errorf("could not extract video ID from URL")
+	feed, err := fetchFeed("https://www.youtube.com/feeds/videos.xml?playlist_id=" + url.QueryEscape(playlistID))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(feed.Entries) >= playlistFeedLimit {
+		return "", nil, fmt.Errorf("playlist %s has %d or more videos, only playlists of up to %d can be expanded", playlistID, playlistFeedLimit, playlistFeedLimit-1)
+	}
+
+	videos := make([]models.VideoMetadata, 0, len(feed.Entries))
+	for _, entry := range feed.Entries {
+		videos = append(videos, models.VideoMetadata{
+			ID:     entry.VideoID,
+			Title:  entry.Title,
+			Author: entry.Author.Name,
+		})
+	}
+
+	if len(videos) == 0 {
+		return "", nil, fmt.Errorf("playlist %s has no videos", playlistID)
+	}
+	return feed.Title, videos, nil
 }
 
-func (s *YouTubeService) GetDownloadURL(videoID string) (*models.RapidAPIResponse, error) {
-	rapidAPIURL := fmt.Sprintf("https://%s/download_video/%s?quality=247", s.APIHost, videoID)
+func (s *YouTubeService) GetDownloadURL(videoID string, itag int) (*models.RapidAPIResponse, error) {
+	if rapidResp, ok := s.cachedDownloadURL(videoID, itag); ok {
+		return rapidResp, nil
+	}
 
-	req, err := http.NewRequest("GET", rapidAPIURL, nil)
+	endpoint := "download_video"
+	if isAudioItag(itag) {
+		endpoint = "download_audio"
+	}
+
+	body, err := s.callAPI(fmt.Sprintf("%s/%s?quality=%d", endpoint, videoID, itag))
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("x-rapidapi-key", s.APIKey)
-	req.Header.Add("x-rapidapi-host", s.APIHost)
+	var rapidResp models.RapidAPIResponse
+	if err := json.Unmarshal(body, &rapidResp); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+
+	if rapidResp.File == "" {
+		// The comment usually explains why there is no file
+		if err := classifyMessage(rapidResp.Comment); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: no download URL returned from API", ErrMalformedResponse)
+	}
+
+	s.cacheDownloadURL(videoID, itag, &rapidResp)
+	return &rapidResp, nil
+}
+
+// callAPI performs a RapidAPI request, keeping track of the quota it spends.
+// Keys that are rejected are benched and the request moves on to the next
+// key in the pool.
+func (s *YouTubeService) callAPI(path string) ([]byte, error) {
+	if s.DailyBudget > 0 && s.Keys.UsedToday() >= s.DailyBudget {
+		return nil, fmt.Errorf("%w: daily budget of %d requests reached", ErrQuotaExceeded, s.DailyBudget)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < s.Keys.Size(); attempt++ {
+		key, err := s.Keys.Acquire()
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+
+		body, retry, err := s.callAPIWithKey(key, path)
+		if !retry {
+			return body, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// callAPIWithKey performs one request. retry is true when the key was
+// rejected and another key may succeed.
+func (s *YouTubeService) callAPIWithKey(key *APIKey, path string) ([]byte, bool, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/%s", key.Host, path), nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	req.Header.Add("x-rapidapi-key", key.Key)
+	req.Header.Add("x-rapidapi-host", key.Host)
 
 	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, false, fmt.Errorf("%w: %v", ErrUpstream, err)
 	}
 	defer resp.Body.Close()
 
+	key.quota.RecordRequest(resp.Header)
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		s.Keys.MarkRateLimited(key)
+		return nil, true, ErrQuotaExceeded
+	case http.StatusUnauthorized, http.StatusForbidden:
+		s.Keys.Cooldown(key, fmt.Sprintf("rejected with status %d", resp.StatusCode))
+		return nil, true, fmt.Errorf("%w: RapidAPI rejected key with status %d", ErrUpstream, resp.StatusCode)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	var rapidResp models.RapidAPIResponse
-	if err := json.Unmarshal(body, &rapidResp); err != nil {
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, statusError("RapidAPI", resp.StatusCode)
+	}
+
+	return body, false, nil
+}
+
+// QuotaExhausted reports whether new RapidAPI requests would be refused,
+// either because every key is out of quota or by our daily budget.
+func (s *YouTubeService) QuotaExhausted() bool {
+	if s.Keys.Exhausted() {
+		return true
+	}
+	return s.DailyBudget > 0 && s.Keys.UsedToday() >= s.DailyBudget
+}
+
+func (s *YouTubeService) QuotaStatus() models.QuotaStatus {
+	status := s.Keys.Status()
+	status.DailyBudget = s.DailyBudget
+	status.Exhausted = s.QuotaExhausted()
+	return status
+}
+
+// Resolve asks RapidAPI for a download URL. The file behind it may still be
+// generating; callers should wait for it with a ReadinessProber.
+func (s *YouTubeService) Resolve(videoID string, itag int) (*models.MediaStream, error) {
+	rapidResp, err := s.GetDownloadURL(videoID, itag)
+	if err != nil {
 		return nil, err
 	}
 
-	if rapidResp.File == "" {
-		return nil, fmt.Errorf("no download URL returned from API")
+	stream := rapidResp.ToMediaStream()
+	if stream.Itag == 0 {
+		stream.Itag = itag
 	}
+	stream.Height = itagHeight(stream.Itag)
+	stream.Provider = s.Name()
+	return &stream, nil
+}
 
-	return &rapidResp, nil
+func (s *YouTubeService) Metadata(videoID string) (*models.VideoMetadata, error) {
+	title, err := s.GetVideoTitle(videoID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.VideoMetadata{ID: videoID, Title: title}, nil
 }
 
-func (s *YouTubeService) WaitForFileReady() {
-	time.Sleep(20 * time.Second)
+// Formats lists the streams RapidAPI can produce for a video.
+func (s *YouTubeService) Formats(videoID string) ([]models.MediaStream, error) {
+	body, err := s.callAPI("get_available_quality/" + videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rapidResp []models.RapidAPIResponse
+	if err := json.Unmarshal(body, &rapidResp); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+
+	formats := make([]models.MediaStream, 0, len(rapidResp))
+	for _, r := range rapidResp {
+		format := r.ToMediaStream()
+		format.Height = itagHeight(format.Itag)
+		formats = append(formats, format)
+	}
+	return formats, nil
 }
 
 func (s *YouTubeService) GetVideoTitle(videoID string) (string, error) {
+	if title, ok := s.cachedTitle(videoID); ok {
+		return title, nil
+	}
+
 	oEmbedURL := fmt.Sprintf("https://www.youtube.com/oembed?url=https://www.youtube.com/watch?v=%s&format=json", videoID)
 
 	req, err := http.NewRequest("GET", oEmbedURL, nil)
@@ -98,12 +289,17 @@ func (s *YouTubeService) GetVideoTitle(videoID string) (string, error) {
 	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %v", ErrUpstream, err)
 	}
 	defer resp.Body.Close()
 
+	// oEmbed also refuses (401/403) videos that play fine but can't be
+	// embedded, so only a 404 says the video is gone
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return "", fmt.Errorf("%w: oembed API returned status %d", ErrUpstream, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("oembed API returned status %d", resp.StatusCode)
+		return "", statusError("oembed API", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -115,12 +311,13 @@ func (s *YouTubeService) GetVideoTitle(videoID string) (string, error) {
 		Title string `json:"title"`
 	}
 	if err := json.Unmarshal(body, &oembedResp); err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %v", ErrMalformedResponse, err)
 	}
 
 	if oembedResp.Title == "" {
-		return "", fmt.Errorf("no title found in oembed response")
+		return "", fmt.Errorf("%w: no title found in oembed response", ErrMalformedResponse)
 	}
 
+	s.cacheTitle(videoID, oembedResp.Title)
 	return oembedResp.Title, nil
 }
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var videoIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// YouTubeURL holds everything we understand from a YouTube link.
+type YouTubeURL struct {
+	VideoID       string
+	StartSeconds  int
+	PlaylistID    string
+	PlaylistIndex int
+}
+
+var youtubeHosts = map[string]bool{
+	"youtube.com":              true,
+	"www.youtube.com":          true,
+	"m.youtube.com":            true,
+	"music.youtube.com":        true,
+	"youtube-nocookie.com":     true,
+	"www.youtube-nocookie.com": true,
+}
+
+// Path prefixes that are followed directly by a video ID.
+var videoPathPrefixes = []string{"/shorts/", "/embed/", "/live/", "/v/", "/e/"}
+
+// ParseYouTubeURL understands watch, youtu.be, shorts, embed, live and
+// playlist links on the desktop, mobile, music and nocookie hosts.
+func ParseYouTubeURL(rawURL string) (*YouTubeURL, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	query := u.Query()
+	result := &YouTubeURL{}
+
+	switch {
+	case host == "youtu.be":
+		result.VideoID = strings.Split(strings.Trim(u.Path, "/"), "/")[0]
+	case youtubeHosts[host]:
+		if u.Path == "/watch" {
+			result.VideoID = query.Get("v")
+		}
+		for _, prefix := range videoPathPrefixes {
+			if strings.HasPrefix(u.Path, prefix) {
+				result.VideoID = strings.Split(strings.TrimPrefix(u.Path, prefix), "/")[0]
+				break
+			}
+		}
+	default:
+		return nil, fmt.Errorf("not a YouTube URL")
+	}
+
+	result.PlaylistID = query.Get("list")
+	result.PlaylistIndex, _ = strconv.Atoi(query.Get("index"))
+
+	// Start time can be in the query (t= or start=) or the fragment (#t=).
+	start := query.Get("t")
+	if start == "" {
+		start = query.Get("start")
+	}
+	if start == "" && u.Fragment != "" {
+		if fragment, err := url.ParseQuery(u.Fragment); err == nil {
+			start = fragment.Get("t")
+		}
+	}
+	if start != "" {
+		result.StartSeconds = parseTimestamp(start)
+	}
+
+	if result.VideoID == "" {
+		if result.PlaylistID != "" {
+			return result, nil
+		}
+		return nil, fmt.Errorf("could not extract video ID from URL")
+	}
+	if !videoIDPattern.MatchString(result.VideoID) {
+		return nil, fmt.Errorf("invalid video ID %q", result.VideoID)
+	}
+
+	return result, nil
+}
+
+// parseTimestamp accepts "90", "90s" and "1h2m3s" style offsets.
+func parseTimestamp(value string) int {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return seconds
+	}
+
+	total := 0
+	number := 0
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			number = number*10 + int(r-'0')
+		case r == 'h':
+			total += number * 3600
+			number = 0
+		case r == 'm':
+			total += number * 60
+			number = 0
+		case r == 's':
+			total += number
+			number = 0
+		default:
+			return 0
+		}
+	}
+	return total + number
+}
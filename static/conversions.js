@@ -33,6 +33,15 @@ function renderConversions() {
                 const videoTitle = job.videoTitle || 'Untitled Video';
 
                 let actions = '';
+                const pauseButton = job.status === 'converting'
+                    ? ''
+                    : `<button onclick="jobAction('${job.id}', 'pause')" class="download-btn">Pause</button>`;
+                const cancelButton = `
+                    <div class="conversion-actions">
+                        ${pauseButton}
+                        <button onclick="cancelConversion('${job.id}')" class="delete-btn">Cancel</button>
+                    </div>
+                `;
                 if (job.status === 'completed' && job.filename) {
                     actions = `
                         <div class="conversion-actions">
@@ -40,8 +49,8 @@ function renderConversions() {
                             <button onclick="deleteConversion('${job.filename}')" class="delete-btn">Delete</button>
                         </div>
                     `;
-                } else if (job.status === 'failed') {
-                    let errorHTML = `<div style="font-size: 13px; opacity: 0.6; margin-bottom: 8px;">Error: ${job.error || 'Unknown error'}</div>`;
+                } else if (job.status === 'failed' || job.status === 'interrupted') {
+                    let errorHTML = `<div style="font-size: 13px; opacity: 0.6; margin-bottom: 8px;">Error: ${job.errorMessage || job.error || 'Unknown error'}</div>`;
                     if (job.canRetry) {
                         errorHTML += `
                             <div class="conversion-actions">
@@ -50,6 +59,32 @@ function renderConversions() {
                         `;
                     }
                     actions = errorHTML;
+                } else if (job.status === 'queued' && job.queuePosition > 0) {
+                    actions = `<div style="font-size: 13px; opacity: 0.6; margin-bottom: 8px;">Position ${job.queuePosition} in queue</div>` + cancelButton;
+                } else if (job.status === 'downloading' && job.bytesTotal > 0) {
+                    const percent = Math.floor(job.bytesDone / job.bytesTotal * 100);
+                    let detail = `${percent}% of ${formatBytes(job.bytesTotal)}`;
+                    if (job.bytesPerSecond > 0) {
+                        detail += ` • ${formatBytes(job.bytesPerSecond)}/s`;
+                    }
+                    if (job.etaSeconds > 0) {
+                        detail += ` • ${formatDuration(job.etaSeconds)} left`;
+                    }
+                    actions = `<div style="font-size: 13px; opacity: 0.6; margin-bottom: 8px;">${detail}</div>` + cancelButton;
+                } else if (['queued', 'resolving', 'downloading', 'muxing', 'converting'].includes(job.status)) {
+                    actions = cancelButton;
+                } else if (job.status === 'paused') {
+                    let detail = 'Paused';
+                    if (job.bytesTotal > 0) {
+                        detail += ` at ${Math.floor(job.bytesDone / job.bytesTotal * 100)}% of ${formatBytes(job.bytesTotal)}`;
+                    }
+                    actions = `
+                        <div style="font-size: 13px; opacity: 0.6; margin-bottom: 8px;">${detail}</div>
+                        <div class="conversion-actions">
+                            <button onclick="jobAction('${job.id}', 'resume')" class="download-btn">Resume</button>
+                            <button onclick="cancelConversion('${job.id}')" class="delete-btn">Cancel</button>
+                        </div>
+                    `;
                 }
 
                 return `
@@ -77,6 +112,23 @@ function renderConversions() {
         });
 }
 
+function formatBytes(bytes) {
+    const units = ['B', 'KB', 'MB', 'GB'];
+    let i = 0;
+    while (bytes >= 1024 && i < units.length - 1) {
+        bytes /= 1024;
+        i++;
+    }
+    return `${bytes.toFixed(i === 0 ? 0 : 1)} ${units[i]}`;
+}
+
+function formatDuration(seconds) {
+    if (seconds < 60) {
+        return `${seconds}s`;
+    }
+    return `${Math.floor(seconds / 60)}m ${seconds % 60}s`;
+}
+
 function deleteConversion(filename) {
     if (!confirm('Are you sure you want to delete this file?')) {
         return;
@@ -107,6 +159,26 @@ function retryConversion(jobId) {
     });
 }
 
+function cancelConversion(jobId) {
+    if (!confirm('Cancel this conversion?')) {
+        return;
+    }
+
+    jobAction(jobId, 'cancel');
+}
+
+function jobAction(jobId, action) {
+    fetch(`/api/jobs/${jobId}/${action}`, {
+        method: 'POST'
+    })
+    .then(response => {
+        renderConversions();
+    })
+    .catch(error => {
+        console.error('Error:', error);
+    });
+}
+
 // Start auto-refresh when page loads
 document.addEventListener('DOMContentLoaded', () => {
     renderConversions();
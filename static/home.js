@@ -44,7 +44,12 @@ function handleObtain() {
         },
         body: JSON.stringify(requestBody)
     })
-    .then(response => response.json())
+    .then(response => response.json().then(data => {
+        if (!response.ok) {
+            throw new Error(data.message || data.error || 'Failed to process video. Please try again.');
+        }
+        return data;
+    }))
     .then(data => {
         input.value = '';
         btn.disabled = false;
@@ -53,19 +58,61 @@ function handleObtain() {
         if (data.status === 'ready') {
             document.getElementById('directDownloadLink').href = data.downloadUrl;
             document.getElementById('directDownloadCard').classList.remove('hidden');
-        } else if (data.status === 'converting') {
+        } else if (data.jobId || data.batchId) {
             document.getElementById('directDownloadCard').classList.add('hidden');
             window.location.href = '/conversions';
         }
     })
     .catch(error => {
         console.error('Error:', error);
-        showError('Failed to process video. Please try again.');
+        showError(error instanceof SyntaxError || error instanceof TypeError
+            ? 'Failed to process video. Please try again.'
+            : error.message);
         btn.disabled = false;
         btn.textContent = 'obtain';
     });
 }
 
+function handleUpload() {
+    const input = document.getElementById('uploadFile');
+    if (input.files.length === 0) {
+        showError('Please choose a file to upload');
+        return;
+    }
+
+    const btn = document.getElementById('uploadBtn');
+    btn.disabled = true;
+    btn.textContent = 'Uploading...';
+    hideError();
+
+    // The format goes first so the server knows it before the file arrives
+    const formData = new FormData();
+    formData.append('format', document.querySelector('input[name="format"]:checked').value);
+    formData.append('file', input.files[0]);
+
+    fetch('/api/upload', {
+        method: 'POST',
+        body: formData
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(data => {
+        if (data.jobId) {
+            window.location.href = '/conversions';
+        }
+    })
+    .catch(error => {
+        console.error('Error:', error);
+        showError(error.message || 'Failed to upload file. Please try again.');
+        btn.disabled = false;
+        btn.textContent = 'upload';
+    });
+}
+
 function showError(message) {
     const errorDiv = document.getElementById('errorMessage');
     errorDiv.textContent = message;
@@ -1,8 +1,27 @@
 package utils
 
-import "os/exec"
+import (
+	"context"
+	"os/exec"
+)
 
-func BuildFFmpegCommand(inputFile, outputFile, format string) *exec.Cmd {
+// ConversionFormats are the output formats BuildFFmpegCommand knows how to
+// produce.
+var ConversionFormats = []string{"mpg", "avi", "mp4"}
+
+// IsConversionFormat reports whether format is one of ConversionFormats.
+func IsConversionFormat(format string) bool {
+	for _, f := range ConversionFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildFFmpegCommand converts inputFile to format. Cancelling ctx kills
+// ffmpeg along with any processes it started.
+func BuildFFmpegCommand(ctx context.Context, inputFile, outputFile, format string) *exec.Cmd {
 	var args []string
 
 	switch format {
@@ -14,5 +33,38 @@ func BuildFFmpegCommand(inputFile, outputFile, format string) *exec.Cmd {
 		args = []string{"-y", "-i", inputFile, "-c:v", "libx264", "-c:a", "aac", outputFile}
 	}
 
+	return commandContext(ctx, "ffmpeg", args...)
+}
+
+// BuildMuxCommand combines a video-only and an audio-only file into one
+// container without re-encoding.
+func BuildMuxCommand(ctx context.Context, videoFile, audioFile, outputFile string) *exec.Cmd {
+	args := []string{"-y", "-i", videoFile, "-i", audioFile, "-map", "0:v:0", "-map", "1:a:0", "-c", "copy", outputFile}
+	return commandContext(ctx, "ffmpeg", args...)
+}
+
+// BuildProbeCommand lists a file's streams and duration, one key=value pair
+// per line, and prints any errors found while reading the container.
+func BuildProbeCommand(inputFile string) *exec.Cmd {
+	args := []string{"-v", "error", "-show_entries", "format=duration:stream=codec_type", "-of", "default=noprint_wrappers=1", inputFile}
+	return exec.Command("ffprobe", args...)
+}
+
+// BuildDecodeCheckCommand decodes the first five seconds of a file, or the
+// last five when fromEnd is set, and throws the result away. Anything wrong
+// with the decoded data is printed as an error.
+func BuildDecodeCheckCommand(inputFile string, fromEnd bool) *exec.Cmd {
+	args := []string{"-nostdin", "-v", "error", "-t", "5", "-i", inputFile, "-f", "null", "-"}
+	if fromEnd {
+		args = []string{"-nostdin", "-v", "error", "-sseof", "-5", "-i", inputFile, "-f", "null", "-"}
+	}
 	return exec.Command("ffmpeg", args...)
 }
+
+// commandContext runs the command in its own process group, so cancelling
+// ctx kills the whole group rather than just the first process.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	return cmd
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package utils
+
+import "os/exec"
+
+// setProcessGroup leaves the default behaviour, which kills only ffmpeg
+// itself, on platforms without process groups.
+func setProcessGroup(cmd *exec.Cmd) {}